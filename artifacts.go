@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// artifactsTable records every artifact created by the optimizer, so the optimizations can be reverted
+const artifactsTable = "pdok_optimizer_artifacts"
+
+const (
+	artifactColumn         = "column"
+	artifactIndex          = "index"
+	artifactTable          = "table"
+	artifactView           = "view"
+	artifactTrigger        = "trigger"
+	artifactGeometryColumn = "geometry_column"
+	artifactCRS            = "srs"
+	artifactExtension      = "extension"
+	artifactMetadata       = "metadata"
+	// row in gpkg_contents
+	artifactContents = "contents"
+	// feature table registered in gpkg_contents and gpkg_geometry_columns, with its RTree index
+	artifactFeatureTable = "feature_table"
+	// rows in gpkg_tile_matrix_set and gpkg_tile_matrix
+	artifactTileMatrixSet = "tile_matrix_set"
+	// constraint in gpkg_data_column_constraints
+	artifactDataColumnConstraint = "data_column_constraint"
+	// renumbered fids of a clustered table, the name is the fid column
+	artifactClustering = "clustering"
+)
+
+type Artifact struct {
+	TableName string `json:"table-name,omitempty"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+}
+
+// recordArtifact registers an artifact created by the optimizer in the artifacts table
+func recordArtifact(tableName string, artifactType string, name string, db *sql.DB) {
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY AUTOINCREMENT, "+
+		"table_name TEXT, artifact_type TEXT NOT NULL, name TEXT NOT NULL, "+
+		"created DATETIME NOT NULL DEFAULT (strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ','now')))", artifactsTable))
+	if err != nil {
+		log.Fatalf("error creating %s: %s", artifactsTable, err)
+	}
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (table_name, artifact_type, name) VALUES (?, ?, ?)", artifactsTable),
+		tableName, artifactType, name)
+	if err != nil {
+		log.Fatalf("error recording %s '%s': %s", artifactType, name, err)
+	}
+}
+
+// readArtifacts returns the recorded artifacts, most recently created first
+func readArtifacts(db *sql.DB) []Artifact {
+	if !tableExists(artifactsTable, db) {
+		return nil
+	}
+	rows, err := db.Query(fmt.Sprintf("select coalesce(table_name, ''), artifact_type, name from %s order by id desc", artifactsTable))
+	if err != nil {
+		log.Fatalf("error selecting %s: %s", artifactsTable, err)
+	}
+	return scanArtifacts(rows)
+}
+
+// readArtifactsSince returns the artifacts recorded after the given id, in order of creation
+func readArtifactsSince(id int64, db *sql.DB) []Artifact {
+	if !tableExists(artifactsTable, db) {
+		return nil
+	}
+	rows, err := db.Query(fmt.Sprintf("select coalesce(table_name, ''), artifact_type, name from %s where id > ? order by id", artifactsTable), id)
+	if err != nil {
+		log.Fatalf("error selecting %s: %s", artifactsTable, err)
+	}
+	return scanArtifacts(rows)
+}
+
+// readLastArtifactID returns the id of the most recently recorded artifact, or 0 if there is none
+func readLastArtifactID(db *sql.DB) int64 {
+	if !tableExists(artifactsTable, db) {
+		return 0
+	}
+	var id int64
+	err := db.QueryRow(fmt.Sprintf("select coalesce(max(id), 0) from %s", artifactsTable)).Scan(&id)
+	if err != nil {
+		log.Fatalf("error selecting %s: %s", artifactsTable, err)
+	}
+	return id
+}
+
+func scanArtifacts(rows *sql.Rows) []Artifact {
+	defer rows.Close()
+
+	var result []Artifact
+	for rows.Next() {
+		var artifact Artifact
+		err := rows.Scan(&artifact.TableName, &artifact.Type, &artifact.Name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result = append(result, artifact)
+	}
+	return result
+}
+
+func revertGeopackage(sourceGeopackage string) {
+	log.Printf("Reverting optimizations of geopackage: '%s'...\n", sourceGeopackage)
+	db := openDb(sourceGeopackage)
+	defer db.Close()
+
+	revertArtifacts(db)
+}
+
+// revertArtifacts removes every recorded artifact in reverse order of creation, so dependent artifacts (e.g. an index on
+// an added column) are removed first. Data changes (e.g. by sql-statements or validation) can't be reverted.
+func revertArtifacts(db *sql.DB) {
+	artifacts := readArtifacts(db)
+	if artifacts == nil {
+		log.Printf("WARNING: no optimizer artifacts found, nothing to revert")
+		return
+	}
+	for _, artifact := range artifacts {
+		revertArtifact(artifact, db)
+	}
+	executeQuery("DROP TABLE "+artifactsTable, db)
+	executeQuery("VACUUM", db)
+}
+
+// revertArtifactsSince removes the artifacts recorded after the given id in reverse order of creation, e.g. to discard
+// an optimization that turns out to be useless, and forgets these artifacts
+func revertArtifactsSince(id int64, db *sql.DB) {
+	artifacts := readArtifactsSince(id, db)
+	for i := len(artifacts) - 1; i >= 0; i-- {
+		revertArtifact(artifacts[i], db)
+	}
+	if tableExists(artifactsTable, db) {
+		_, err := db.Exec(fmt.Sprintf("delete from %s where id > ?", artifactsTable), id)
+		if err != nil {
+			log.Fatalf("error removing reverted artifacts from %s: %s", artifactsTable, err)
+		}
+	}
+}
+
+// revertArtifact removes a single artifact
+func revertArtifact(artifact Artifact, db *sql.DB) {
+	log.Printf("Removing %s '%s' of table '%s'", artifact.Type, artifact.Name, artifact.TableName)
+	switch artifact.Type {
+	case artifactTrigger:
+		executeQuery(fmt.Sprintf("DROP TRIGGER IF EXISTS \"%s\"", artifact.Name), db)
+	case artifactTable:
+		executeQuery(fmt.Sprintf("DROP TABLE IF EXISTS \"%s\"", artifact.Name), db)
+	case artifactView:
+		executeQuery(fmt.Sprintf("DROP VIEW IF EXISTS \"%s\"", artifact.Name), db)
+	case artifactIndex:
+		executeQuery(fmt.Sprintf("DROP INDEX IF EXISTS \"%s\"", artifact.Name), db)
+	case artifactColumn:
+		if columnExists(artifact.TableName, artifact.Name, db) {
+			executeQuery(fmt.Sprintf("ALTER TABLE \"%s\" DROP COLUMN \"%s\"", artifact.TableName, artifact.Name), db)
+		}
+		forgetColumn(artifact.TableName, artifact.Name, db)
+	case artifactGeometryColumn:
+		_, err := db.Exec("delete from gpkg_geometry_columns where table_name = ? and column_name = ?", artifact.TableName, artifact.Name)
+		if err != nil {
+			log.Fatalf("error removing geometry column '%s' from gpkg_geometry_columns: %s", artifact.Name, err)
+		}
+	case artifactCRS:
+		revertCRS(artifact.Name, db)
+	case artifactExtension:
+		unregisterExtension(artifact.TableName, artifact.Name, db)
+	case artifactMetadata:
+		revertMetadata(artifact.Name, db)
+	case artifactContents:
+		_, err := db.Exec("delete from gpkg_contents where table_name = ?", artifact.Name)
+		if err != nil {
+			log.Fatalf("error removing '%s' from gpkg_contents: %s", artifact.Name, err)
+		}
+	case artifactFeatureTable:
+		removeTable(Table{Name: artifact.Name, GeometryColumns: readGeometryColumns(db)[artifact.Name]}, db)
+	case artifactTileMatrixSet:
+		revertTileMatrixSet(artifact.Name, db)
+	case artifactDataColumnConstraint:
+		revertDataColumnConstraint(artifact.Name, db)
+	case artifactClustering:
+		revertClustering(artifact.TableName, artifact.Name, db)
+	default:
+		log.Fatalf("unknown artifact type '%s'", artifact.Type)
+	}
+}
+
+// revertCRS removes an added CRS from gpkg_spatial_ref_sys, unless it is still in use
+func revertCRS(srsID string, db *sql.DB) {
+	references := "select srs_id from gpkg_contents"
+	if tableExists("gpkg_geometry_columns", db) {
+		references += " union select srs_id from gpkg_geometry_columns"
+	}
+	var inUse int
+	err := db.QueryRow(fmt.Sprintf("select exists(select 1 from (%s) where srs_id = ?)", references), srsID).Scan(&inUse)
+	if err != nil {
+		log.Fatalf("error reading references to srs_id %s: %s", srsID, err)
+	}
+	if inUse == 1 {
+		log.Printf("WARNING: srs_id %s is still in use, it is not removed", srsID)
+		return
+	}
+	_, err = db.Exec("delete from gpkg_spatial_ref_sys where srs_id = ?", srsID)
+	if err != nil {
+		log.Fatalf("error removing srs_id %s from gpkg_spatial_ref_sys: %s", srsID, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestRevertArtifacts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, identificatie TEXT)", db)
+	executeQuery("INSERT INTO pand (identificatie) VALUES ('a'), ('b')", db)
+	addColumn("pand", "external_fid", "TEXT", db)
+	setColumnValue("pand", "external_fid", "identificatie", db)
+	createIndex("pand", []string{"external_fid"}, "pand_external_fid_idx", false, db)
+
+	if artifacts := readArtifacts(db); len(artifacts) != 2 || artifacts[0].Type != artifactIndex {
+		log.Fatalf("expected recorded column and index, most recent first, got %v", artifacts)
+	}
+
+	revertArtifacts(db)
+
+	columns := readColumns("pand", db)
+	if len(columns) != 2 || columnExists("pand", "external_fid", db) {
+		log.Fatalf("expected original columns, got %v", columns)
+	}
+	if indexes := readIndexes("pand", db); len(indexes) != 0 {
+		log.Fatalf("expected no indexes, got %v", indexes)
+	}
+	if tableExists(artifactsTable, db) {
+		log.Fatalf("expected table '%s' to be removed", artifactsTable)
+	}
+}
+
+func TestRevertFeatureTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL)", db)
+	executeQuery("CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, "+
+		"geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL)", db)
+	executeQuery("CREATE TABLE gpkg_extensions (table_name TEXT, column_name TEXT, extension_name TEXT NOT NULL, "+
+		"definition TEXT NOT NULL, scope TEXT NOT NULL)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand', 'features')", db)
+	executeQuery("INSERT INTO gpkg_geometry_columns VALUES ('pand', 'geom', 'POLYGON', 28992, 0, 0)", db)
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, geom BLOB)", db)
+
+	// a companion table, as created by createCompanionTable
+	executeQuery("CREATE TABLE pand_geom_epsg4326 (fid INTEGER PRIMARY KEY NOT NULL, geom BLOB)", db)
+	recordArtifact("pand", artifactFeatureTable, "pand_geom_epsg4326", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand_geom_epsg4326', 'features')", db)
+	executeQuery("INSERT INTO gpkg_geometry_columns VALUES ('pand_geom_epsg4326', 'geom', 'POLYGON', 4326, 0, 0)", db)
+	executeQuery("CREATE VIRTUAL TABLE rtree_pand_geom_epsg4326_geom USING rtree(id, minx, maxx, miny, maxy)", db)
+	registerExtension("pand_geom_epsg4326", "geom", rtreeExtension, rtreeExtensionDefinition, "write-only", db)
+	addColumn("pand_geom_epsg4326", "minx", "numeric", db)
+
+	revertArtifacts(db)
+
+	if tableExists("pand_geom_epsg4326", db) || tableExists("rtree_pand_geom_epsg4326_geom", db) || !tableExists("pand", db) {
+		log.Fatal("expected the companion table and its RTree to be removed, and nothing else")
+	}
+	for _, registry := range []string{"gpkg_contents", "gpkg_geometry_columns"} {
+		if tables := queryStrings("select table_name from "+registry, db); len(tables) != 1 || tables[0] != "pand" {
+			log.Fatalf("expected only 'pand' in %s, got %v", registry, tables)
+		}
+	}
+	if extensions := queryStrings("select table_name from gpkg_extensions", db); len(extensions) != 0 {
+		log.Fatalf("expected the RTree extension to be removed, got %v", extensions)
+	}
+}
+
+func TestRevertArtifactsSince(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, identificatie TEXT)", db)
+	addColumn("pand", "external_fid", "TEXT", db)
+	lastArtifactID := readLastArtifactID(db)
+	addColumn("pand", "label_x", "numeric", db)
+	createIndex("pand", []string{"label_x"}, "pand_label_idx", false, db)
+
+	// only the artifacts recorded after the given id are removed and forgotten
+	revertArtifactsSince(lastArtifactID, db)
+	if columnExists("pand", "label_x", db) || !columnExists("pand", "external_fid", db) {
+		log.Fatalf("expected only column 'label_x' to be removed, got %v", readColumns("pand", db))
+	}
+	if indexes := readIndexes("pand", db); len(indexes) != 0 {
+		log.Fatalf("expected no indexes, got %v", indexes)
+	}
+	if artifacts := readArtifacts(db); len(artifacts) != 1 || artifacts[0].Name != "external_fid" {
+		log.Fatalf("expected only the artifacts before the given id, got %v", artifacts)
+	}
+}
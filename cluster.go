@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	originalFidColumn = "original_fid"
+	// temporary mapping of the current to the new fids
+	clusterTable = "pdok_optimizer_cluster"
+)
+
+// clusterFeatureTables stores the features of every feature table in the order of the given space-filling curve, see
+// clusterFeatureTable. Companion tables are renumbered along with their table.
+func clusterFeatureTables(curve string, report *Report, db *sql.DB) {
+	checkCurve(curve)
+	companions := readCompanionTables(db)
+	parents := companionParents(companions)
+	for _, table := range readTables(db) {
+		if _, isCompanion := parents[table.Name]; !table.IsFeatures || isCompanion {
+			continue
+		}
+		clusterFeatureTable(table, curve, companions[table.Name], report, db)
+	}
+}
+
+// clusterFeatureTable renumbers the features of the given table in the order of the given space-filling curve over the
+// center of their bbox, within the extent of the table. SQLite stores the rows of a table in order of the integer
+// primary key (fid), so this is the only way to store nearby features on the same pages while keeping the fid an alias
+// of the rowid: spatial queries and paging by fid then read fewer pages. The original fid of every feature is preserved
+// in column original_fid and restored by revert.
+//
+// The given companion tables are renumbered likewise, so they still join by fid. Columns derived from the fid (e.g.
+// external_fid and relations) keep their value, as do the RTree index, search index and feature count which are
+// updated by their triggers. References to the fid that are not maintained by the optimizer need to be updated using
+// original_fid.
+func clusterFeatureTable(table Table, curve string, companions []string, report *Report, db *sql.DB) {
+	fidColumn, geomColumn := report.table(table.Name).FidColumn, report.table(table.Name).GeomColumn
+	if fidColumn == "" {
+		fidColumn = detectFidColumn(table.Name, db)
+	}
+	if geomColumn == "" {
+		geomColumn = Layer{}.geometryColumns(table)[0]
+	}
+	log.Printf("Clustering table '%s' along the %s curve", table.Name, curve)
+
+	var extent [4]sql.NullFloat64
+	err := db.QueryRow(fmt.Sprintf("select min(ST_MinX(\"%[1]s\")), min(ST_MinY(\"%[1]s\")), max(ST_MaxX(\"%[1]s\")), "+
+		"max(ST_MaxY(\"%[1]s\")) from \"%[2]s\"", geomColumn, table.Name)).Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("error reading extent of table '%s': %s", table.Name, err)
+	}
+	if !extent[0].Valid {
+		log.Printf("WARNING: table '%s' is not clustered, since it contains no geometries", table.Name)
+		return
+	}
+
+	addColumn(table.Name, originalFidColumn, "INTEGER", db)
+	setColumnValue(table.Name, originalFidColumn, fidColumn, db)
+	describeColumn(table.Name, originalFidColumn, "Original fid", fmt.Sprintf("Fid of the feature before the table "+
+		"was clustered along the %s curve", curve), "", db)
+	indexName := fmt.Sprintf("%s_%s_idx", table.Name, originalFidColumn)
+	createIndex(table.Name, []string{originalFidColumn}, indexName, true, db)
+
+	key := curveKeyExpression(curve,
+		fmt.Sprintf("(ST_MinX(\"%[1]s\") + ST_MaxX(\"%[1]s\")) / 2", geomColumn),
+		fmt.Sprintf("(ST_MinY(\"%[1]s\") + ST_MaxY(\"%[1]s\")) / 2", geomColumn),
+		[4]float64{extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64})
+	// features without geometry go last
+	renumberFeatures(table.Name, fidColumn, companions, fmt.Sprintf("SELECT fid, row_number() OVER (ORDER BY key IS NULL, key, fid) "+
+		"FROM (SELECT \"%s\" AS fid, %s AS key FROM \"%s\")", fidColumn, key, table.Name), db)
+	recordArtifact(table.Name, artifactClustering, fidColumn, db)
+
+	report.table(table.Name).Clustering = &ClusteringReport{
+		Curve:       curve,
+		OriginalFid: originalFidColumn,
+		Index:       indexName,
+	}
+}
+
+// revertClustering restores the original fids of a clustered table and its companion tables
+func revertClustering(tableName string, fidColumn string, db *sql.DB) {
+	if !columnExists(tableName, originalFidColumn, db) {
+		log.Printf("WARNING: the original fids of table '%s' can't be restored, since column '%s' is missing", tableName, originalFidColumn)
+		return
+	}
+	var companions []string
+	for _, companion := range readCompanionTables(db)[tableName] {
+		if tableExists(companion, db) {
+			companions = append(companions, companion)
+		}
+	}
+	renumberFeatures(tableName, fidColumn, companions, fmt.Sprintf("SELECT \"%s\", \"%s\" FROM \"%s\"",
+		fidColumn, originalFidColumn, tableName), db)
+}
+
+// renumberFeatures gives the features of the given table and its companion tables the new fids selected by the given
+// query (current fid, new fid). The fids are changed in two steps, first above the highest current and new fid of all
+// tables, so no fid is used twice at any time.
+func renumberFeatures(tableName string, fidColumn string, companions []string, newFids string, db *sql.DB) {
+	executeQuery(fmt.Sprintf("CREATE TABLE %s (fid INTEGER PRIMARY KEY, new_fid INTEGER NOT NULL)", clusterTable), db)
+	executeQuery(fmt.Sprintf("INSERT INTO %s %s", clusterTable, newFids), db)
+
+	tables := append([]string{tableName}, companions...)
+	maxFids := []string{fmt.Sprintf("coalesce((select max(new_fid) from %s), 0)", clusterTable)}
+	for _, name := range tables {
+		maxFids = append(maxFids, fmt.Sprintf("coalesce((select max(\"%s\") from \"%s\"), 0)", fidColumn, name))
+	}
+	var offset int64
+	err := db.QueryRow("select max(" + strings.Join(maxFids, ", ") + ")").Scan(&offset)
+	if err != nil {
+		log.Fatalf("error reading fids of table '%s': %s", tableName, err)
+	}
+	for _, name := range tables {
+		executeQuery(fmt.Sprintf("UPDATE \"%[1]s\" SET \"%[2]s\" = %[3]d + (SELECT new_fid FROM %[4]s c WHERE c.fid = \"%[1]s\".\"%[2]s\") "+
+			"WHERE \"%[2]s\" IN (SELECT fid FROM %[4]s)", name, fidColumn, offset, clusterTable), db)
+		executeQuery(fmt.Sprintf("UPDATE \"%[1]s\" SET \"%[2]s\" = \"%[2]s\" - %[3]d WHERE \"%[2]s\" > %[3]d", name, fidColumn, offset), db)
+	}
+	executeQuery("DROP TABLE "+clusterTable, db)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRevertClustering(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY AUTOINCREMENT, naam TEXT)", db)
+	executeQuery("INSERT INTO pand VALUES (10, 'a'), (20, 'b'), (30, 'c')", db)
+	executeQuery("CREATE TABLE pand_geom_epsg4326 (fid INTEGER PRIMARY KEY NOT NULL, geom TEXT)", db)
+	executeQuery("INSERT INTO pand_geom_epsg4326 VALUES (10, 'a'), (20, 'b'), (30, 'c')", db)
+	recordArtifact("pand", artifactFeatureTable, "pand_geom_epsg4326", db)
+
+	// renumber in reverse order, as clusterFeatureTable does along a curve
+	addColumn("pand", originalFidColumn, "INTEGER", db)
+	setColumnValue("pand", originalFidColumn, "fid", db)
+	renumberFeatures("pand", "fid", []string{"pand_geom_epsg4326"}, "SELECT fid, 4 - fid / 10 FROM pand", db)
+	recordArtifact("pand", artifactClustering, "fid", db)
+
+	if names := strings.Join(queryStrings("select naam from pand order by fid", db), ","); names != "c,b,a" {
+		log.Fatalf("expected the features renumbered in reverse order, got %s", names)
+	}
+	var mismatches int
+	err = db.QueryRow("select count(*) from pand p left join pand_geom_epsg4326 c on c.fid = p.fid where c.geom is not p.naam").Scan(&mismatches)
+	if err != nil || mismatches != 0 {
+		log.Fatalf("expected the companion table to be renumbered along, got %d mismatches (%v)", mismatches, err)
+	}
+
+	revertClustering("pand", "fid", db)
+	if fids := strings.Join(queryStrings("select fid || naam from pand order by fid", db), ","); fids != "10a,20b,30c" {
+		log.Fatalf("expected the original fids, got %s", fids)
+	}
+	if fids := strings.Join(queryStrings("select fid || geom from pand_geom_epsg4326 order by fid", db), ","); fids != "10a,20b,30c" {
+		log.Fatalf("expected the original fids in the companion table, got %s", fids)
+	}
+}
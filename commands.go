@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
+
+var commands []command
+
+func init() {
+	// initialized in init, since the help command refers to the list of commands
+	commands = []command{
+		{"optimize", "optimize a GeoPackage for a service type (default when no command is given)", optimize},
+		{"plan", "show which optimizations would be performed, without modifying the GeoPackage", plan},
+		{"inspect", "show tables, columns, indexes and optimizer state of a GeoPackage", inspect},
+		{"validate", "check whether a GeoPackage conforms to the GeoPackage specification", validate},
+		{"revert", "remove all columns, indexes and other artifacts added by the optimizer", revert},
+		{"split", "split a GeoPackage in parts by table, grid cell or attribute value, with a manifest", split},
+		{"merge", "merge GeoPackages with identical schemas into one GeoPackage and optimize it", merge},
+		{"help", "show this help", help},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func help(_ []string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nUse '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// legacyArgs supports the commandline from before subcommands existed, where the source geopackage
+// may be given as first argument (e.g. through the Docker entrypoint)
+func legacyArgs(args []string) []string {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return append([]string{"-s"}, args...)
+	}
+	return args
+}
+
+type optimizeFlags struct {
+	sourceGeopackage *string
+	serviceType      *string
+	config           *string
+	reportPath       *string
+	queryablesPath   *string
+	goKoalaPath      *string
+	mapfilePath      *string
+	force            *bool
+	gpkgMetadata     *bool
+	cluster          *string
+}
+
+func newOptimizeFlags(flags *flag.FlagSet, geopackageUsage string) optimizeFlags {
+	return optimizeFlags{
+		sourceGeopackage: flags.String("s", "empty", geopackageUsage),
+		serviceType:      flags.String("service-type", "ows", "service type to optimize geopackage for: ows, oaf or tiles"),
+		config:           flags.String("config", "", "optional JSON config for additional optimizations"),
+		reportPath:       flags.String("report", "", "optional path to write a JSON report of the performed optimizations to"),
+		queryablesPath:   flags.String("queryables", "", "optional path to write OGC API Features queryables (JSON schema) to, only for service-type oaf"),
+		goKoalaPath:      flags.String("gokoala", "", "optional path to write a GoKoala config snippet (YAML) to, only for service-type oaf"),
+		mapfilePath:      flags.String("mapfile", "", "optional path to write MapServer mapfile LAYER blocks to, only for service-type ows"),
+		force:            flags.Bool("force", false, "optimize even when the geopackage was already optimized"),
+		gpkgMetadata:     flags.Bool("gpkg-metadata", false, "also add the optimizer provenance to gpkg_metadata (metadata extension)"),
+		cluster:          flags.String("cluster", "", "optional space-filling curve (hilbert or morton) to renumber the features of every feature table along, the original fids are kept in column original_fid"),
+	}
+}
+
+func (o optimizeFlags) options(dryRun bool) optimizeOptions {
+	return optimizeOptions{dryRun: dryRun, force: *o.force, gpkgMetadata: *o.gpkgMetadata, cluster: *o.cluster}
+}
+
+func (o optimizeFlags) writeOutputs(report *Report) {
+	if *o.reportPath != "" {
+		writeReport(report, *o.reportPath)
+	}
+	if *o.queryablesPath != "" {
+		writeQueryables(report, *o.queryablesPath)
+	}
+	if *o.goKoalaPath != "" {
+		writeGoKoalaConfig(report, *o.goKoalaPath)
+	}
+	if *o.mapfilePath != "" {
+		writeMapfile(report, *o.mapfilePath)
+	}
+}
+
+// optimize optimizes a GeoPackage for a service type
+func optimize(args []string) {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	options := newOptimizeFlags(flags, "source geopackage")
+	_ = flags.Parse(args)
+
+	report := optimizeGeopackage(*options.sourceGeopackage, *options.serviceType, *options.config, options.options(false))
+	options.writeOutputs(report)
+}
+
+// plan performs all optimizations for a service type in a transaction that is rolled back, so the statements
+// that would be executed are logged and the report shows what would be created
+func plan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	options := newOptimizeFlags(flags, "source geopackage")
+	_ = flags.Parse(args)
+
+	report := optimizeGeopackage(*options.sourceGeopackage, *options.serviceType, *options.config, options.options(true))
+	options.writeOutputs(report)
+	log.Printf("Plan finished, geopackage '%s' is not modified", *options.sourceGeopackage)
+}
+
+// inspect shows tables, columns, indexes and optimizer state of a GeoPackage
+func inspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	sourceGeopackage := flags.String("s", "empty", "source geopackage")
+	outputPath := flags.String("output", "", "optional path to write the JSON inspection to, instead of stdout")
+	_ = flags.Parse(args)
+
+	inspection := inspectGeopackage(*sourceGeopackage)
+	if *outputPath != "" {
+		writeJSON(inspection, *outputPath)
+		return
+	}
+	printJSON(inspection)
+}
+
+// validate checks whether a GeoPackage conforms to the GeoPackage specification, exits with a non-zero exit code if not
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	sourceGeopackage := flags.String("s", "empty", "source geopackage")
+	reportPath := flags.String("report", "", "optional path to write a JSON conformance report to")
+	_ = flags.Parse(args)
+
+	report := validateGeopackage(*sourceGeopackage)
+	if *reportPath != "" {
+		writeReport(report, *reportPath)
+	}
+	if !report.Passed {
+		log.Fatalf("GeoPackage '%s' does not conform to the GeoPackage specification", *sourceGeopackage)
+	}
+	log.Printf("GeoPackage '%s' conforms to the GeoPackage specification", *sourceGeopackage)
+}
+
+// revert removes all artifacts added by the optimizer from a GeoPackage
+func revert(args []string) {
+	flags := flag.NewFlagSet("revert", flag.ExitOnError)
+	sourceGeopackage := flags.String("s", "empty", "source geopackage")
+	_ = flags.Parse(args)
+
+	revertGeopackage(*sourceGeopackage)
+}
+
+// split splits a GeoPackage in parts and writes a manifest describing the parts
+func split(args []string) {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	sourceGeopackage := flags.String("s", "empty", "source geopackage")
+	by := flags.String("by", splitByTable, "split by table, grid or attribute")
+	gridSize := flags.Float64("grid-size", 0, "size of the grid cells in units of the CRS, only for -by grid")
+	column := flags.String("column", "", "column of which every value gets its own part, only for -by attribute")
+	outputDir := flags.String("output", ".", "directory to write the parts to")
+	manifestPath := flags.String("manifest", "", "optional path to write the JSON manifest to, default manifest.json in the output directory")
+	_ = flags.Parse(args)
+
+	manifest := splitGeopackage(*sourceGeopackage, splitOptions{by: *by, gridSize: *gridSize, column: *column, outputDir: *outputDir})
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(*outputDir, "manifest.json")
+	}
+	writeJSON(manifest, *manifestPath)
+	log.Printf("Split geopackage '%s' in %d parts", *sourceGeopackage, len(manifest.Parts))
+}
+
+// merge merges the GeoPackages given as arguments into a new GeoPackage and optimizes it for a service type
+func merge(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	options := newOptimizeFlags(flags, "merged geopackage to write and optimize")
+	_ = flags.Parse(args)
+	// flags after the first geopackage are not parsed
+	for _, input := range flags.Args() {
+		if strings.HasPrefix(input, "-") {
+			log.Fatalf("flag '%s' is given after the geopackages to merge, flags must come before the geopackages", input)
+		}
+	}
+
+	mergeReport := mergeGeopackages(flags.Args(), *options.sourceGeopackage)
+	report := optimizeGeopackage(*options.sourceGeopackage, *options.serviceType, *options.config, options.options(false))
+	report.Merge = mergeReport
+	options.writeOutputs(report)
+	log.Printf("Merged %d geopackages into '%s'", len(mergeReport.Inputs), *options.sourceGeopackage)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+const (
+	rtreeExtension           = "gpkg_rtree_index"
+	rtreeExtensionDefinition = "http://www.geopackage.org/spec/#extension_rtree"
+)
+
+// createCompanionTable creates a feature table with the given name, containing the fid of the given table and a single
+// geometry column filled with the given SQL expression over the given table (e.g. a transformed or simplified
+// geometry). gpkg_geometry_columns allows only one geometry column per table, so derived geometries are stored in
+// companion tables, which are joined to the table by fid. The companion table is registered in gpkg_contents and
+// gpkg_geometry_columns with an RTree spatial index and, like the table itself, gets bbox columns and a spatial index.
+func createCompanionTable(table Table, fidColumn string, name string, column GeometryColumn, value string, description string, db *sql.DB) Table {
+	if tableExists(name, db) {
+		log.Fatalf("cannot create companion table '%s' of table '%s', since it already exists", name, table.Name)
+	}
+	log.Printf("Creating companion table '%s' of table '%s'", name, table.Name)
+	executeQuery(fmt.Sprintf("CREATE TABLE \"%s\" (\"%s\" INTEGER PRIMARY KEY NOT NULL, \"%s\" %s)",
+		name, fidColumn, column.Name, column.GeometryType), db)
+	recordArtifact(table.Name, artifactFeatureTable, name, db)
+
+	_, err := db.Exec("insert into gpkg_contents (table_name, data_type, identifier, description, srs_id) values (?, 'features', ?, ?, ?)",
+		name, name, description, column.SrsID)
+	if err != nil {
+		log.Fatalf("error registering '%s' in gpkg_contents: %s", name, err)
+	}
+	registerGeometryColumn(name, column, db)
+	// the RTree is filled by its triggers
+	executeQuery(fmt.Sprintf("SELECT gpkgAddSpatialIndex('%s', '%s')", sqlString(name), sqlString(column.Name)), db)
+	registerExtension(name, column.Name, rtreeExtension, rtreeExtensionDefinition, "write-only", db)
+
+	executeQuery(fmt.Sprintf("INSERT INTO \"%s\" (\"%s\", \"%s\") SELECT \"%s\", %s FROM \"%s\"",
+		name, fidColumn, column.Name, fidColumn, value, table.Name), db)
+
+	companion := Table{Name: name, DataType: "features", IsFeatures: true, GeometryColumns: []GeometryColumn{column}}
+	recomputeContents(companion, db)
+	addOAFDefaultOptimizations(companion, fidColumn, []string{column.Name}, nil, db)
+	return companion
+}
+
+// readCompanionTables returns the names of the companion tables per table, as recorded in the artifacts
+func readCompanionTables(db *sql.DB) map[string][]string {
+	companions := make(map[string][]string)
+	for _, artifact := range readArtifacts(db) {
+		if artifact.Type == artifactFeatureTable {
+			companions[artifact.TableName] = append(companions[artifact.TableName], artifact.Name)
+		}
+	}
+	return companions
+}
+
+// companionParents returns the table of every companion table, given the companion tables per table
+func companionParents(companions map[string][]string) map[string]string {
+	parents := make(map[string]string)
+	for parent, names := range companions {
+		for _, name := range names {
+			parents[name] = parent
+		}
+	}
+	return parents
+}
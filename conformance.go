@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	gpkgApplicationID = 0x47504B47 // "GPKG"
+	gp10ApplicationID = 0x47503130 // "GP10", GeoPackage 1.0
+	gp11ApplicationID = 0x47503131 // "GP11", GeoPackage 1.1
+	minUserVersion    = 10200
+
+	// maximum number of offending rows mentioned per check
+	maxReportedRows = 10
+)
+
+type ConformanceReport struct {
+	Geopackage string             `json:"geopackage"`
+	Passed     bool               `json:"passed"`
+	Checks     []ConformanceCheck `json:"checks"`
+}
+
+type ConformanceCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r *ConformanceReport) add(name string, passed bool, message string) {
+	r.Checks = append(r.Checks, ConformanceCheck{Name: name, Passed: passed, Message: message})
+	r.Passed = r.Passed && passed
+	result := "PASS"
+	if !passed {
+		result = "FAIL"
+	}
+	log.Printf("%s %s %s", result, name, message)
+}
+
+// validateGeopackage checks whether the given GeoPackage conforms to the GeoPackage specification
+func validateGeopackage(sourceGeopackage string) *ConformanceReport {
+	log.Printf("Validating geopackage: '%s'...\n", sourceGeopackage)
+	db := openDb(sourceGeopackage)
+	defer db.Close()
+
+	report := checkConformance(db)
+	report.Geopackage = sourceGeopackage
+	return report
+}
+
+func checkConformance(db *sql.DB) *ConformanceReport {
+	report := &ConformanceReport{Passed: true}
+	checkHeader(report, db)
+
+	missingTables := false
+	for _, tableName := range []string{"gpkg_spatial_ref_sys", "gpkg_contents"} {
+		exists := tableExists(tableName, db)
+		report.add("required table "+tableName, exists, "")
+		missingTables = missingTables || !exists
+	}
+	if missingTables {
+		// remaining checks depend on the required tables
+		return report
+	}
+	// gpkg_geometry_columns is only required when the GeoPackage contains feature tables
+	if len(queryStrings("select table_name from gpkg_contents where data_type = 'features'", db)) > 0 {
+		exists := tableExists("gpkg_geometry_columns", db)
+		report.add("required table gpkg_geometry_columns", exists, "")
+		if !exists {
+			return report
+		}
+	}
+
+	checkSpatialRefSys(report, db)
+	tables := readTables(db)
+	for _, table := range tables {
+		checkContents(table, report, db)
+		for _, geomColumn := range table.GeometryColumns {
+			checkGeometries(table, geomColumn, report, db)
+		}
+	}
+	if tableExists("gpkg_extensions", db) {
+		checkExtensions(report, db)
+	}
+	if tableExists("gpkg_data_columns", db) {
+		checkDataColumns(report, db)
+	}
+	return report
+}
+
+func checkHeader(report *ConformanceReport, db *sql.DB) {
+	var applicationID, userVersion int64
+	err := db.QueryRow("pragma application_id").Scan(&applicationID)
+	if err != nil {
+		log.Fatalf("error reading application_id: %s", err)
+	}
+	err = db.QueryRow("pragma user_version").Scan(&userVersion)
+	if err != nil {
+		log.Fatalf("error reading user_version: %s", err)
+	}
+
+	switch applicationID {
+	case gpkgApplicationID:
+		report.add("application_id", true, "")
+		report.add("user_version", userVersion >= minUserVersion, fmt.Sprintf("user_version is %d", userVersion))
+	case gp10ApplicationID, gp11ApplicationID:
+		report.add("application_id", true, "GeoPackage 1.0/1.1")
+	default:
+		report.add("application_id", false, fmt.Sprintf("application_id is 0x%08X, expected 0x%08X", applicationID, gpkgApplicationID))
+	}
+}
+
+func checkSpatialRefSys(report *ConformanceReport, db *sql.DB) {
+	for _, srsID := range []int64{-1, 0, 4326} {
+		var exists int
+		err := db.QueryRow("select exists(select 1 from gpkg_spatial_ref_sys where srs_id = ?)", srsID).Scan(&exists)
+		if err != nil {
+			log.Fatalf("error reading gpkg_spatial_ref_sys: %s", err)
+		}
+		report.add(fmt.Sprintf("required srs_id %d", srsID), exists == 1, "")
+	}
+
+	for _, reference := range []string{"gpkg_contents", "gpkg_geometry_columns"} {
+		if !tableExists(reference, db) {
+			continue
+		}
+		missing := queryStrings(fmt.Sprintf("select distinct table_name || ' (' || srs_id || ')' from %s "+
+			"where srs_id is not null and srs_id not in (select srs_id from gpkg_spatial_ref_sys)", reference), db)
+		report.add("srs_id references of "+reference, len(missing) == 0, describeRows("unknown srs_id", missing))
+	}
+}
+
+func checkContents(table Table, report *ConformanceReport, db *sql.DB) {
+	if !tableExists(table.Name, db) {
+		report.add(fmt.Sprintf("table %s", table.Name), false, "table in gpkg_contents does not exist")
+		return
+	}
+	if !table.IsFeatures {
+		return
+	}
+	if len(table.GeometryColumns) == 0 {
+		report.add(fmt.Sprintf("geometry column of %s", table.Name), false, "feature table is missing in gpkg_geometry_columns")
+		return
+	}
+	columns := readColumns(table.Name, db)
+	for _, geomColumn := range table.GeometryColumns {
+		_, exists := findColumn(columns, geomColumn.Name)
+		report.add(fmt.Sprintf("geometry column %s.%s", table.Name, geomColumn.Name), exists, "")
+	}
+}
+
+// checkGeometries checks the header of every geometry blob against the declared geometry column
+func checkGeometries(table Table, geomColumn GeometryColumn, report *ConformanceReport, db *sql.DB) {
+	rows, err := db.Query(fmt.Sprintf("select rowid, \"%s\" from \"%s\" where \"%s\" is not null", geomColumn.Name, table.Name, geomColumn.Name))
+	if err != nil {
+		log.Printf("WARNING: cannot read geometries of %s.%s: %s", table.Name, geomColumn.Name, err)
+		return
+	}
+	defer rows.Close()
+
+	var invalid []string
+	for rows.Next() {
+		var rowid int64
+		var blob []byte
+		err = rows.Scan(&rowid, &blob)
+		if err != nil {
+			log.Fatal(err)
+		}
+		header, err := parseGeometryHeader(blob)
+		switch {
+		case err != nil:
+			invalid = append(invalid, fmt.Sprintf("%d: %s", rowid, err))
+		case int64(header.SrsID) != geomColumn.SrsID:
+			invalid = append(invalid, fmt.Sprintf("%d: srs_id %d instead of %d", rowid, header.SrsID, geomColumn.SrsID))
+		case header.Extended:
+			continue
+		case !geometryTypeMatches(geomColumn.GeometryType, header.GeometryType):
+			invalid = append(invalid, fmt.Sprintf("%d: %s instead of %s", rowid, geometryTypeNames[header.GeometryType], geomColumn.GeometryType))
+		case (header.HasZ && geomColumn.Z == 0) || (!header.HasZ && geomColumn.Z == 1):
+			invalid = append(invalid, fmt.Sprintf("%d: z %t while z is %d", rowid, header.HasZ, geomColumn.Z))
+		case (header.HasM && geomColumn.M == 0) || (!header.HasM && geomColumn.M == 1):
+			invalid = append(invalid, fmt.Sprintf("%d: m %t while m is %d", rowid, header.HasM, geomColumn.M))
+		}
+	}
+	report.add(fmt.Sprintf("geometries of %s.%s", table.Name, geomColumn.Name), len(invalid) == 0, describeRows("invalid geometry blobs (rowid)", invalid))
+}
+
+func checkExtensions(report *ConformanceReport, db *sql.DB) {
+	rows, err := db.Query("select table_name, column_name, extension_name from gpkg_extensions")
+	if err != nil {
+		log.Fatalf("error selecting gpkg_extensions: %s", err)
+	}
+	type extension struct {
+		table, column sql.NullString
+		name          string
+	}
+	var extensions []extension
+	for rows.Next() {
+		var e extension
+		err = rows.Scan(&e.table, &e.column, &e.name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		extensions = append(extensions, e)
+	}
+	rows.Close()
+
+	for _, e := range extensions {
+		name := "extension " + e.name
+		if e.table.Valid {
+			name += " on " + e.table.String
+			if e.column.Valid {
+				name += "." + e.column.String
+			}
+		}
+		switch {
+		case !strings.Contains(e.name, "_"):
+			report.add(name, false, "extension name must be of the form <author>_<extension>")
+		case e.table.Valid && !tableExists(e.table.String, db):
+			report.add(name, false, "table does not exist")
+		case e.column.Valid && !columnExists(e.table.String, e.column.String, db):
+			report.add(name, false, "column does not exist")
+		case e.name == "gpkg_rtree_index" && !tableExists(fmt.Sprintf("rtree_%s_%s", e.table.String, e.column.String), db):
+			report.add(name, false, "rtree table does not exist")
+		default:
+			report.add(name, true, "")
+		}
+	}
+}
+
+// checkDataColumns checks that every column described in gpkg_data_columns exists and refers to an existing constraint
+func checkDataColumns(report *ConformanceReport, db *sql.DB) {
+	rows, err := db.Query("select table_name, column_name, coalesce(constraint_name, '') from gpkg_data_columns")
+	if err != nil {
+		log.Fatalf("error selecting gpkg_data_columns: %s", err)
+	}
+	type dataColumn struct {
+		table, column, constraint string
+	}
+	var dataColumns []dataColumn
+	for rows.Next() {
+		var c dataColumn
+		err = rows.Scan(&c.table, &c.column, &c.constraint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dataColumns = append(dataColumns, c)
+	}
+	rows.Close()
+
+	constraints := make(map[string]bool)
+	if tableExists("gpkg_data_column_constraints", db) {
+		for _, name := range queryStrings("select constraint_name from gpkg_data_column_constraints", db) {
+			constraints[name] = true
+		}
+	}
+	for _, c := range dataColumns {
+		name := fmt.Sprintf("data column %s.%s", c.table, c.column)
+		switch {
+		case !columnExists(c.table, c.column, db):
+			report.add(name, false, "column does not exist")
+		case c.constraint != "" && !constraints[c.constraint]:
+			report.add(name, false, fmt.Sprintf("constraint '%s' does not exist", c.constraint))
+		default:
+			report.add(name, true, "")
+		}
+	}
+}
+
+func tableExists(tableName string, db *sql.DB) bool {
+	var exists int
+	err := db.QueryRow("select exists(select 1 from sqlite_master where type in ('table', 'view') and name = ?)", tableName).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error reading sqlite_master: %s", err)
+	}
+	return exists == 1
+}
+
+func columnExists(tableName string, columnName string, db *sql.DB) bool {
+	_, exists := findColumn(readColumns(tableName, db), columnName)
+	return exists
+}
+
+func queryStrings(query string, db *sql.DB, args ...any) []string {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Fatalf("error executing query: '%s'", err)
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func queryInts(query string, db *sql.DB) []int {
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Fatalf("error executing query: '%s'", err)
+	}
+	defer rows.Close()
+
+	var result []int
+	for rows.Next() {
+		var value int
+		err = rows.Scan(&value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+// describeRows summarizes the given offending rows in a message, mentioning at most maxReportedRows rows
+func describeRows(description string, rows []string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	message := fmt.Sprintf("%d %s: %s", len(rows), description, strings.Join(rows[:min(len(rows), maxReportedRows)], ", "))
+	if len(rows) > maxReportedRows {
+		message += ", ..."
+	}
+	return message
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckConformance(t *testing.T) {
+	// a copy is checked, so no -shm or -wal files are created next to the fixture
+	geopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	source, err := os.Open("testdata/original_ows.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+	defer source.Close()
+	destination, _ := os.Create(geopackage)
+	_, err = io.Copy(destination, source)
+	destination.Close()
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3", geopackage)
+	if err != nil {
+		log.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	report := checkConformance(db)
+
+	checks := make(map[string]ConformanceCheck)
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	for _, name := range []string{"application_id", "user_version", "required table gpkg_contents", "required srs_id 4326",
+		"geometry column layer.geom", "geometries of layer.geom", "extension gpkg_rtree_index on layer.geom"} {
+		if !checks[name].Passed {
+			log.Fatalf("expected check '%s' to pass: %v", name, checks[name])
+		}
+	}
+	if !report.Passed {
+		log.Fatal("expected GeoPackage to conform")
+	}
+}
+
+func TestCheckConformanceInvalid(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL)", db)
+
+	report := checkConformance(db)
+	if report.Passed {
+		log.Fatal("expected empty database not to conform")
+	}
+	for _, check := range report.Checks {
+		if check.Name == "required table gpkg_contents" && !check.Passed {
+			log.Fatal("expected check 'required table gpkg_contents' to pass")
+		}
+	}
+}
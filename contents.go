@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// updateExtent sets the extent of the given table in gpkg_contents, based on the bbox columns of its features. The
+// extent is left alone when the table has no bbox columns (e.g. a table without config) or contains no geometries.
+func updateExtent(table Table, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		return
+	}
+	if !columnExists(table.Name, "minx", db) {
+		log.Printf("WARNING: extent of table '%s' is not updated, since it has no bbox columns", table.Name)
+		return
+	}
+	var extent [4]sql.NullFloat64
+	err := db.QueryRow(fmt.Sprintf("select min(minx), min(miny), max(maxx), max(maxy) from \"%s\"", table.Name)).
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("error reading extent of table '%s': %s", table.Name, err)
+	}
+	if !extent[0].Valid {
+		log.Printf("WARNING: extent of table '%s' is not updated, since it contains no geometries", table.Name)
+		return
+	}
+	_, err = db.Exec("UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?",
+		extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64, table.Name)
+	if err != nil {
+		log.Fatalf("error updating extent of table '%s': %s", table.Name, err)
+	}
+	report.table(table.Name).Extent = []float64{extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64}
+}
+
+// updateFeatureCount stores the number of features of the given table in gpkg_ogr_contents, so clients don't
+// need to count the rows. Triggers (the same as created by GDAL) keep the count up to date.
+func updateFeatureCount(table Table, report *Report, db *sql.DB) {
+	if !tableExists("gpkg_ogr_contents", db) {
+		executeQuery("CREATE TABLE gpkg_ogr_contents(table_name TEXT NOT NULL PRIMARY KEY, feature_count INTEGER DEFAULT NULL)", db)
+		recordArtifact("", artifactTable, "gpkg_ogr_contents", db)
+	}
+	executeQuery(fmt.Sprintf("INSERT OR REPLACE INTO gpkg_ogr_contents(table_name, feature_count) "+
+		"VALUES ('%[1]s', (select count(*) from '%[1]s'))", table.Name), db)
+
+	triggers := map[string]string{
+		"insert": "UPDATE gpkg_ogr_contents SET feature_count = feature_count + 1 WHERE lower(table_name) = lower('%[1]s')",
+		"delete": "UPDATE gpkg_ogr_contents SET feature_count = feature_count - 1 WHERE lower(table_name) = lower('%[1]s')",
+	}
+	for _, event := range []string{"insert", "delete"} {
+		trigger := fmt.Sprintf("trigger_%s_feature_count_%s", event, table.Name)
+		if triggerExists(trigger, db) {
+			continue
+		}
+		executeQuery(fmt.Sprintf("CREATE TRIGGER \"%s\" AFTER %s ON \"%s\" BEGIN %s; END",
+			trigger, strings.ToUpper(event), table.Name, fmt.Sprintf(triggers[event], table.Name)), db)
+		recordArtifact(table.Name, artifactTrigger, trigger, db)
+	}
+
+	var count int64
+	err := db.QueryRow("select feature_count from gpkg_ogr_contents where table_name = ?", table.Name).Scan(&count)
+	if err != nil {
+		log.Fatalf("error reading feature count of table '%s': %s", table.Name, err)
+	}
+	report.table(table.Name).FeatureCount = count
+}
+
+// recomputeContents brings the extent in gpkg_contents and the feature count in gpkg_ogr_contents in line with the rows
+// of the given table, using the geometries instead of the bbox columns (which may not exist)
+func recomputeContents(table Table, db *sql.DB) {
+	if table.IsFeatures && len(table.GeometryColumns) > 0 {
+		executeQuery(fmt.Sprintf("UPDATE gpkg_contents SET "+
+			"min_x = (select min(ST_MinX(\"%[1]s\")) from \"%[2]s\"), min_y = (select min(ST_MinY(\"%[1]s\")) from \"%[2]s\"), "+
+			"max_x = (select max(ST_MaxX(\"%[1]s\")) from \"%[2]s\"), max_y = (select max(ST_MaxY(\"%[1]s\")) from \"%[2]s\") "+
+			"WHERE table_name = '%[2]s'", table.GeometryColumns[0].Name, table.Name), db)
+	}
+	if tableExists("gpkg_ogr_contents", db) {
+		executeQuery(fmt.Sprintf("UPDATE gpkg_ogr_contents SET feature_count = (select count(*) from \"%[1]s\") "+
+			"WHERE table_name = '%[1]s'", table.Name), db)
+	}
+}
+
+func triggerExists(triggerName string, db *sql.DB) bool {
+	var exists int
+	err := db.QueryRow("select exists(select 1 from sqlite_master where type = 'trigger' and name = ?)", triggerName).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error reading sqlite_master: %s", err)
+	}
+	return exists == 1
+}
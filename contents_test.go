@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestUpdateExtentWithoutGeometries(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, "+
+		"min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand', 'features', 1, 2, 3, 4)", db)
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, minx numeric, maxx numeric, miny numeric, maxy numeric)", db)
+	executeQuery("INSERT INTO pand (fid) VALUES (1)", db)
+
+	report := newReport("", "oaf")
+	updateExtent(Table{Name: "pand", DataType: "features", IsFeatures: true}, report, db)
+
+	var extent [4]float64
+	err = db.QueryRow("select min_x, min_y, max_x, max_y from gpkg_contents where table_name = 'pand'").
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil || extent != [4]float64{1, 2, 3, 4} {
+		log.Fatalf("expected the existing extent to be kept, got %v (%v)", extent, err)
+	}
+	if report.table("pand").Extent != nil {
+		log.Fatalf("expected no extent in the report, got %v", report.table("pand").Extent)
+	}
+}
+
+func TestUpdateExtentWithoutBBoxColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, "+
+		"min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand', 'features', 1, 2, 3, 4)", db)
+	// a feature table without config, which got no bbox columns
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, geom BLOB)", db)
+	executeQuery("INSERT INTO pand (fid) VALUES (1)", db)
+
+	report := newReport("", "oaf")
+	updateExtent(Table{Name: "pand", DataType: "features", IsFeatures: true}, report, db)
+
+	var extent [4]float64
+	err = db.QueryRow("select min_x, min_y, max_x, max_y from gpkg_contents where table_name = 'pand'").
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil || extent != [4]float64{1, 2, 3, 4} {
+		log.Fatalf("expected the existing extent to be kept, got %v (%v)", extent, err)
+	}
+	if report.table("pand").Extent != nil {
+		log.Fatalf("expected no extent in the report, got %v", report.table("pand").Extent)
+	}
+}
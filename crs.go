@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// well-known CRSs, which are added to gpkg_spatial_ref_sys when missing
+const (
+	epsgRDNew       = 28992
+	epsgWGS84       = 4326
+	epsgWebMercator = 3857
+)
+
+const (
+	// reference point (Amersfoort) of the RD New approximation
+	rdX0   = 155000.0
+	rdY0   = 463000.0
+	rdLat0 = 52.15517440
+	rdLon0 = 5.38720621
+
+	// number of points sampled on each edge of a bbox, since straight edges don't stay straight after transformation
+	bboxEdgeSamples = 8
+)
+
+type crsDefinition struct {
+	name       string
+	definition string
+}
+
+// definitions of well-known CRSs, used to register these CRSs in gpkg_spatial_ref_sys when missing
+var wellKnownCRSDefinitions = map[int64]crsDefinition{
+	epsgRDNew:       {"Amersfoort / RD New", `PROJCS["Amersfoort / RD New",GEOGCS["Amersfoort",DATUM["Amersfoort",SPHEROID["Bessel 1841",6377397.155,299.1528128,AUTHORITY["EPSG","7004"]],TOWGS84[565.2369,50.0087,465.658,-0.406857,0.350733,-1.87035,4.0812],AUTHORITY["EPSG","6289"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4289"]],PROJECTION["Oblique_Stereographic"],PARAMETER["latitude_of_origin",52.1561605555556],PARAMETER["central_meridian",5.38763888888889],PARAMETER["scale_factor",0.9999079],PARAMETER["false_easting",155000],PARAMETER["false_northing",463000],UNIT["metre",1,AUTHORITY["EPSG","9001"]],AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","28992"]]`},
+	epsgWGS84:       {"WGS 84 geodetic", `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AXIS["Latitude",NORTH],AXIS["Longitude",EAST],AUTHORITY["EPSG","4326"]]`},
+	epsgWebMercator: {"WGS 84 / Pseudo-Mercator", `PROJCS["WGS 84 / Pseudo-Mercator",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]],PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",0],PARAMETER["scale_factor",1],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1,AUTHORITY["EPSG","9001"]],AXIS["Easting",EAST],AXIS["Northing",NORTH],EXTENSION["PROJ4","+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs"],AUTHORITY["EPSG","3857"]]`},
+}
+
+var bboxFunctions = map[string]string{"minx": "ST_MinX", "maxx": "ST_MaxX", "miny": "ST_MinY", "maxy": "ST_MaxY"}
+
+type polynomialTerm struct {
+	p, q int
+	c    float64
+}
+
+// coefficients of the approximation of RD New <-> WGS84 by Schreutelaar, accurate to about a meter within the
+// Netherlands (rdBounds)
+var (
+	rdToLatTerms = []polynomialTerm{
+		{0, 1, 3235.65389}, {2, 0, -32.58297}, {0, 2, -0.24750}, {2, 1, -0.84978}, {0, 3, -0.06550},
+		{2, 2, -0.01709}, {1, 0, -0.00738}, {4, 0, 0.00530}, {2, 3, -0.00039}, {4, 1, 0.00033}, {1, 1, -0.00012},
+	}
+	rdToLonTerms = []polynomialTerm{
+		{1, 0, 5260.52916}, {1, 1, 105.94684}, {1, 2, 2.45656}, {3, 0, -0.81885}, {1, 3, 0.05594}, {3, 1, -0.05607},
+		{0, 1, 0.01199}, {3, 2, -0.00256}, {1, 4, 0.00128}, {0, 2, 0.00022}, {2, 0, -0.00022}, {5, 0, 0.00026},
+	}
+	wgs84ToRDXTerms = []polynomialTerm{
+		{0, 1, 190094.945}, {1, 1, -11832.228}, {2, 1, -114.221}, {0, 3, -32.391}, {1, 0, -0.705},
+		{3, 1, -2.340}, {1, 3, -0.608}, {0, 2, -0.008}, {2, 3, 0.148},
+	}
+	wgs84ToRDYTerms = []polynomialTerm{
+		{1, 0, 309056.544}, {0, 2, 3638.893}, {2, 0, 73.077}, {1, 2, -157.984}, {3, 0, 59.788},
+		{0, 1, 0.433}, {2, 2, -6.439}, {1, 1, -0.032}, {0, 4, 0.092}, {1, 4, -0.054},
+	}
+
+	// area of use of RD New, in RD New and in WGS84 (lon/lat), outside this area the approximation diverges
+	rdBounds      = [4]float64{12628.0541, 308179.0423, 283594.4779, 611063.1429}
+	rdBoundsWGS84 = [4]float64{3.2, 50.75, 7.22, 53.7}
+)
+
+// errOutsideRDBounds is returned when a point outside the area of use of RD New is transformed in Go, such points
+// are transformed by SpatiaLite instead
+var errOutsideRDBounds = errors.New("point is outside the area of use of RD New")
+
+func evaluatePolynomial(terms []polynomialTerm, a, b float64) float64 {
+	var result float64
+	for _, term := range terms {
+		result += term.c * math.Pow(a, float64(term.p)) * math.Pow(b, float64(term.q))
+	}
+	return result
+}
+
+func isWellKnownCRS(epsg int64) bool {
+	return epsg == epsgRDNew || epsg == epsgWGS84 || epsg == epsgWebMercator
+}
+
+// canTransformInGo returns whether points can be transformed in Go from one CRS to the other, which is only the case
+// for RD New <-> WGS84 (within rdBounds)
+func canTransformInGo(from, to int64) bool {
+	return (from == epsgRDNew && to == epsgWGS84) || (from == epsgWGS84 && to == epsgRDNew)
+}
+
+func withinBounds(x, y float64, bounds [4]float64) bool {
+	return x >= bounds[0] && y >= bounds[1] && x <= bounds[2] && y <= bounds[3]
+}
+
+// transformPoint transforms a point between RD New and WGS84 (lon/lat order) using the approximation by Schreutelaar,
+// accurate to about a meter. Returns errOutsideRDBounds for points outside the Netherlands.
+func transformPoint(x, y float64, from, to int64) (float64, float64, error) {
+	switch {
+	case !canTransformInGo(from, to):
+		return 0, 0, fmt.Errorf("cannot transform from EPSG:%d to EPSG:%d", from, to)
+	case from == epsgRDNew:
+		if !withinBounds(x, y, rdBounds) {
+			return 0, 0, errOutsideRDBounds
+		}
+		dx, dy := (x-rdX0)*1e-5, (y-rdY0)*1e-5
+		return rdLon0 + evaluatePolynomial(rdToLonTerms, dx, dy)/3600, rdLat0 + evaluatePolynomial(rdToLatTerms, dx, dy)/3600, nil
+	default:
+		if !withinBounds(x, y, rdBoundsWGS84) {
+			return 0, 0, errOutsideRDBounds
+		}
+		dLat, dLon := 0.36*(y-rdLat0), 0.36*(x-rdLon0)
+		return rdX0 + evaluatePolynomial(wgs84ToRDXTerms, dLat, dLon), rdY0 + evaluatePolynomial(wgs84ToRDYTerms, dLat, dLon), nil
+	}
+}
+
+// bboxEdgePoints returns points sampled along the edges of a bbox, since straight edges don't stay straight after
+// transformation
+func bboxEdgePoints(minx, miny, maxx, maxy float64) [][2]float64 {
+	var points [][2]float64
+	for i := 0; i <= bboxEdgeSamples; i++ {
+		fraction := float64(i) / bboxEdgeSamples
+		x, y := minx+fraction*(maxx-minx), miny+fraction*(maxy-miny)
+		points = append(points, [2]float64{x, miny}, [2]float64{x, maxy}, [2]float64{minx, y}, [2]float64{maxx, y})
+	}
+	return points
+}
+
+// transformBBox transforms a bbox between RD New and WGS84 (see transformPoint) and returns the envelope
+// (minx, miny, maxx, maxy) of the transformed bbox, by sampling points along its edges
+func transformBBox(minx, miny, maxx, maxy float64, from, to int64) ([4]float64, error) {
+	result := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, point := range bboxEdgePoints(minx, miny, maxx, maxy) {
+		tx, ty, err := transformPoint(point[0], point[1], from, to)
+		if err != nil {
+			return result, err
+		}
+		result[0], result[1] = math.Min(result[0], tx), math.Min(result[1], ty)
+		result[2], result[3] = math.Max(result[2], tx), math.Max(result[3], ty)
+	}
+	return result, nil
+}
+
+// sqlTransformBBox is available in SQL as pdok_transform_bbox(minx, miny, maxx, maxy, from, to, component),
+// returns the given component (minx, miny, maxx or maxy) of the transformed bbox, or NULL when the bbox is outside
+// the area of use of RD New (see transformBBoxExpression)
+func sqlTransformBBox(minx, miny, maxx, maxy any, from, to int64, component string) (any, error) {
+	var bbox [4]float64
+	for i, value := range []any{minx, miny, maxx, maxy} {
+		switch v := value.(type) {
+		case float64:
+			bbox[i] = v
+		case int64:
+			bbox[i] = float64(v)
+		default:
+			// empty or NULL geometry
+			return nil, nil
+		}
+	}
+	transformed, err := transformBBox(bbox[0], bbox[1], bbox[2], bbox[3], from, to)
+	if errors.Is(err, errOutsideRDBounds) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch component {
+	case "minx":
+		return transformed[0], nil
+	case "miny":
+		return transformed[1], nil
+	case "maxx":
+		return transformed[2], nil
+	case "maxy":
+		return transformed[3], nil
+	default:
+		return nil, fmt.Errorf("invalid bbox component '%s'", component)
+	}
+}
+
+// transformBBoxExpression returns the SQL expression of the given component (minx, miny, maxx or maxy) of the bbox
+// of the given geometry column transformed to another CRS. Between RD New and WGS84 the bbox columns are transformed
+// in Go (pdok_transform_bbox), falling back to SpatiaLite (ST_Transform) outside the Netherlands and for other CRSs.
+func transformBBoxExpression(geomColumn string, from, to int64, component string) string {
+	value := fmt.Sprintf("%s(ST_Transform(GeomFromGPB(\"%s\"), %d))", bboxFunctions[component], geomColumn, to)
+	if canTransformInGo(from, to) {
+		value = fmt.Sprintf("coalesce(pdok_transform_bbox(minx, miny, maxx, maxy, %d, %d, '%s'), %s)", from, to, component, value)
+	}
+	return value
+}
+
+// addBBoxCRSColumns adds bbox columns (prefixed with epsg<code>_) and a spatial index for every additional CRS of
+// the given table, so bbox queries in these CRSs don't need to transform every feature. Requires the bbox columns
+// of the primary geometry column to be present.
+func addBBoxCRSColumns(table Table, layerCfg Layer, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		return
+	}
+	source, ok := table.geometryColumn(layerCfg.GeomColumn)
+	if !ok {
+		log.Fatalf("cannot determine CRS of geometry column '%s' of table '%s'", layerCfg.GeomColumn, table.Name)
+	}
+
+	for _, crs := range layerCfg.BBoxCRS {
+		prefix := fmt.Sprintf("epsg%d_", crs)
+		for _, component := range []string{"minx", "maxx", "miny", "maxy"} {
+			addColumn(table.Name, prefix+component, "numeric", db)
+		}
+		for _, component := range []string{"minx", "maxx", "miny", "maxy"} {
+			setColumnValue(table.Name, prefix+component, transformBBoxExpression(layerCfg.GeomColumn, source.SrsID, crs, component), db)
+		}
+		describeBBoxColumns(table.Name, prefix, fmt.Sprintf("geometry column '%s' in EPSG:%d", layerCfg.GeomColumn, crs), db)
+
+		indexName := fmt.Sprintf("%s_epsg%d_spatial_idx", table.Name, crs)
+		createSpatialIndex(table.Name, layerCfg.FidColumn, prefix, layerCfg.TemporalColumns, indexName, db)
+
+		report.table(table.Name).BBoxes = append(report.table(table.Name).BBoxes, BBoxReport{
+			CRS:    crs,
+			Prefix: prefix,
+			Index:  indexName,
+		})
+	}
+}
+
+// addCRSGeometryColumns materializes the primary geometry of the given table in every additional CRS, so these CRSs
+// can be served without transforming geometries on the fly. The transformed geometries are stored in a companion
+// table <table>_<geometry column>_epsg<code> per CRS, with its own bbox columns and spatial index.
+func addCRSGeometryColumns(table Table, layerCfg Layer, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		return
+	}
+	source, ok := table.geometryColumn(layerCfg.GeomColumn)
+	if !ok {
+		log.Fatalf("cannot determine CRS of geometry column '%s' of table '%s'", layerCfg.GeomColumn, table.Name)
+	}
+
+	for _, crs := range layerCfg.AdditionalCRS {
+		registerCRS(crs, db)
+
+		companionName := fmt.Sprintf("%s_%s_epsg%d", table.Name, source.Name, crs)
+		companion := createCompanionTable(table, layerCfg.FidColumn, companionName, GeometryColumn{
+			Name:         source.Name,
+			GeometryType: source.GeometryType,
+			SrsID:        crs,
+			Z:            source.Z,
+			M:            source.M,
+		}, fmt.Sprintf("AsGPB(ST_Transform(GeomFromGPB(\"%s\"), %d))", source.Name, crs),
+			fmt.Sprintf("Geometry column '%s' of table '%s' transformed to EPSG:%d", source.Name, table.Name, crs), db)
+
+		report.table(table.Name).AdditionalCRS = append(report.table(table.Name).AdditionalCRS, CRSGeometryReport{
+			CRS:    crs,
+			Table:  companion.Name,
+			Column: source.Name,
+			Index:  fmt.Sprintf("%s_spatial_idx", companion.Name),
+		})
+	}
+}
+
+// registerCRS adds the given CRS to gpkg_spatial_ref_sys when missing. Only well-known CRSs can be added,
+// other CRSs must already be present (e.g. added by sql-statements).
+func registerCRS(epsg int64, db *sql.DB) {
+	var exists int
+	err := db.QueryRow("select exists(select 1 from gpkg_spatial_ref_sys where srs_id = ?)", epsg).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error reading gpkg_spatial_ref_sys: %s", err)
+	}
+	if exists == 1 {
+		return
+	}
+	crs, ok := wellKnownCRSDefinitions[epsg]
+	if !ok {
+		log.Fatalf("CRS EPSG:%d is missing in gpkg_spatial_ref_sys and cannot be added automatically", epsg)
+	}
+	log.Printf("Adding CRS EPSG:%d to gpkg_spatial_ref_sys", epsg)
+	_, err = db.Exec("insert into gpkg_spatial_ref_sys(srs_name, srs_id, organization, organization_coordsys_id, definition) "+
+		"values (?, ?, 'EPSG', ?, ?)", crs.name, epsg, epsg, crs.definition)
+	if err != nil {
+		log.Fatalf("error adding CRS EPSG:%d to gpkg_spatial_ref_sys: %s", epsg, err)
+	}
+	recordArtifact("", artifactCRS, fmt.Sprint(epsg), db)
+}
+
+// registerGeometryColumn adds the geometry column of the given table to gpkg_geometry_columns. Since
+// gpkg_geometry_columns allows only one geometry column per table (UNIQUE(table_name)), derived geometries are stored
+// in companion tables, see createCompanionTable.
+func registerGeometryColumn(tableName string, column GeometryColumn, db *sql.DB) {
+	_, err := db.Exec("insert into gpkg_geometry_columns(table_name, column_name, geometry_type_name, srs_id, z, m) "+
+		"values (?, ?, ?, ?, ?, ?)", tableName, column.Name, column.GeometryType, column.SrsID, column.Z, column.M)
+	if err != nil {
+		log.Fatalf("cannot register geometry column '%s' of table '%s' in gpkg_geometry_columns: %s", column.Name, tableName, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestTransformPoint(t *testing.T) {
+	// reference point Amersfoort
+	lon, lat, err := transformPoint(155000, 463000, epsgRDNew, epsgWGS84)
+	if err != nil {
+		log.Fatalf("error transforming point: %s", err)
+	}
+	if math.Abs(lon-5.38720621) > 1e-9 || math.Abs(lat-52.15517440) > 1e-9 {
+		log.Fatalf("expected Amersfoort at 5.38720621, 52.15517440, got %f, %f", lon, lat)
+	}
+
+	// round trips should be accurate within a meter
+	x, y, err := transformPoint(121000, 487000, epsgRDNew, epsgWGS84)
+	if err != nil {
+		log.Fatalf("error transforming point: %s", err)
+	}
+	x, y, err = transformPoint(x, y, epsgWGS84, epsgRDNew)
+	if err != nil {
+		log.Fatalf("error transforming point: %s", err)
+	}
+	if math.Abs(x-121000) > 1 || math.Abs(y-487000) > 1 {
+		log.Fatalf("round trip via EPSG:4326 is inaccurate, got %f, %f", x, y)
+	}
+
+	// the approximation is only valid in the Netherlands
+	if _, _, err = transformPoint(2.35, 48.85, epsgWGS84, epsgRDNew); !errors.Is(err, errOutsideRDBounds) {
+		log.Fatalf("expected point outside the Netherlands to be rejected, got %v", err)
+	}
+	if _, _, err = transformPoint(0, 0, epsgRDNew, epsgWGS84); !errors.Is(err, errOutsideRDBounds) {
+		log.Fatalf("expected point outside the Netherlands to be rejected, got %v", err)
+	}
+	for _, pair := range [][2]int64{{epsgRDNew, epsgWebMercator}, {epsgWebMercator, epsgWGS84}, {2000, epsgWGS84}} {
+		if _, _, err = transformPoint(155000, 463000, pair[0], pair[1]); err == nil || errors.Is(err, errOutsideRDBounds) {
+			log.Fatalf("expected error transforming from EPSG:%d to EPSG:%d in Go", pair[0], pair[1])
+		}
+	}
+}
+
+func TestTransformBBox(t *testing.T) {
+	bbox, err := transformBBox(3.5, 51, 7, 53.5, epsgWGS84, epsgRDNew)
+	if err != nil {
+		log.Fatalf("error transforming bbox: %s", err)
+	}
+	// the edges bulge, so the envelope is larger than the transformed corners
+	x, y, _ := transformPoint(3.5, 51, epsgWGS84, epsgRDNew)
+	if bbox[0] > x || bbox[1] > y || bbox[2] < bbox[0] || bbox[3] < bbox[1] {
+		log.Fatalf("unexpected transformed bbox: %v", bbox)
+	}
+
+	if _, err = transformBBox(3.5, 51, 8, 53.5, epsgWGS84, epsgRDNew); !errors.Is(err, errOutsideRDBounds) {
+		log.Fatalf("expected bbox outside the Netherlands to be rejected, got %v", err)
+	}
+	value, err := sqlTransformBBox(3.5, 51, 8.0, 53.5, epsgWGS84, epsgRDNew, "minx")
+	if err != nil || value != nil {
+		log.Fatalf("expected NULL for bbox outside the Netherlands, got %v, %v", value, err)
+	}
+}
+
+func TestTransformBBoxExpression(t *testing.T) {
+	expression := transformBBoxExpression("geom", epsgRDNew, epsgWGS84, "minx")
+	if !strings.HasPrefix(expression, "coalesce(pdok_transform_bbox(") || !strings.Contains(expression, "ST_MinX(ST_Transform(") {
+		log.Fatalf("expected transformation in Go with SpatiaLite fallback, got %s", expression)
+	}
+	expression = transformBBoxExpression("geom", epsgRDNew, epsgWebMercator, "maxy")
+	if expression != `ST_MaxY(ST_Transform(GeomFromGPB("geom"), 3857))` {
+		log.Fatalf("expected transformation by SpatiaLite, got %s", expression)
+	}
+}
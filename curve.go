@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// space-filling curves, which map a 2D position to a 1D key such that nearby positions mostly have nearby keys
+const (
+	curveHilbert = "hilbert"
+	// Z-order
+	curveMorton = "morton"
+)
+
+// number of bits per dimension of a curve key, i.e. the extent is divided in 2^curveOrder x 2^curveOrder cells
+const curveOrder = 24
+
+// curveKey returns the key of the cell containing the given position on the given curve over the given extent
+// (minx, miny, maxx, maxy). Positions outside the extent are clamped to the outermost cells.
+func curveKey(curve string, x, y float64, extent [4]float64) (int64, error) {
+	cellX, cellY := curveCell(x, extent[0], extent[2]), curveCell(y, extent[1], extent[3])
+	switch curve {
+	case curveHilbert:
+		return int64(hilbertKey(cellX, cellY)), nil
+	case curveMorton:
+		return int64(mortonKey(cellX, cellY)), nil
+	default:
+		return 0, fmt.Errorf("unknown space-filling curve '%s', supported are %s and %s", curve, curveHilbert, curveMorton)
+	}
+}
+
+// checkCurve stops when the given space-filling curve is not supported
+func checkCurve(curve string) {
+	if _, err := curveKey(curve, 0, 0, [4]float64{}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// curveKeyExpression returns the SQL expression of the key of the position given by the SQL expressions x and y on
+// the given curve, within the given extent
+func curveKeyExpression(curve string, x string, y string, extent [4]float64) string {
+	return fmt.Sprintf("pdok_curve_key('%s', %s, %s, %s, %s, %s, %s)", curve, x, y,
+		strconv.FormatFloat(extent[0], 'f', -1, 64), strconv.FormatFloat(extent[1], 'f', -1, 64),
+		strconv.FormatFloat(extent[2], 'f', -1, 64), strconv.FormatFloat(extent[3], 'f', -1, 64))
+}
+
+// curveCell returns the cell (0 to 2^curveOrder - 1) of the given coordinate between min and max
+func curveCell(value, min, max float64) uint32 {
+	cells := float64(uint32(1) << curveOrder)
+	if max <= min {
+		return 0
+	}
+	cell := math.Floor((value - min) / (max - min) * cells)
+	return uint32(math.Max(0, math.Min(cells-1, cell)))
+}
+
+// hilbertKey returns the distance of the given cell along the Hilbert curve
+func hilbertKey(x, y uint32) uint64 {
+	var key uint64
+	n := uint32(1) << curveOrder
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		key += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// rotate the quadrant, so the curve is continuous
+		if ry == 0 {
+			if rx == 1 {
+				x, y = n-1-x, n-1-y
+			}
+			x, y = y, x
+		}
+	}
+	return key
+}
+
+// mortonKey returns the Z-order key of the given cell, which interleaves the bits of x and y
+func mortonKey(x, y uint32) uint64 {
+	var key uint64
+	for i := 0; i < curveOrder; i++ {
+		key |= uint64(x>>i&1)<<(2*i) | uint64(y>>i&1)<<(2*i+1)
+	}
+	return key
+}
+
+// sqlCurveKey is available in SQL as pdok_curve_key(curve, x, y, minx, miny, maxx, maxy), returns the key of the given
+// position on the given curve over the given extent
+func sqlCurveKey(curve string, x, y, minx, miny, maxx, maxy any) (any, error) {
+	var values [6]float64
+	for i, value := range []any{x, y, minx, miny, maxx, maxy} {
+		switch v := value.(type) {
+		case float64:
+			values[i] = v
+		case int64:
+			values[i] = float64(v)
+		default:
+			// empty or NULL geometry
+			return nil, nil
+		}
+	}
+	key, err := curveKey(curve, values[0], values[1], [4]float64{values[2], values[3], values[4], values[5]})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
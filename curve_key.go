@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// addCurveKey adds a column <curve>_key containing the key of the center of the bbox of every feature on the given
+// space-filling curve, within the extent of the table, and an index on it. Sorting on the key orders the features
+// spatially, ranges of keys split the table in spatially coherent parts (e.g. for tiling jobs). Requires the bbox
+// columns, see addOAFDefaultOptimizations.
+func addCurveKey(table Table, curve string, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		log.Printf("Skipping curve key for table '%s' because it is not of type 'features'", table.Name)
+		return
+	}
+	checkCurve(curve)
+
+	var extent [4]sql.NullFloat64
+	err := db.QueryRow(fmt.Sprintf("select min(minx), min(miny), max(maxx), max(maxy) from \"%s\"", table.Name)).
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("error reading extent of table '%s': %s", table.Name, err)
+	}
+	if !extent[0].Valid {
+		log.Printf("WARNING: no curve key for table '%s', since it contains no geometries", table.Name)
+		return
+	}
+	keyExtent := [4]float64{extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64}
+
+	column := curve + "_key"
+	addColumn(table.Name, column, "INTEGER", db)
+	setColumnValue(table.Name, column, curveKeyExpression(curve, "(minx + maxx) / 2", "(miny + maxy) / 2", keyExtent), db)
+	describeColumn(table.Name, column, fmt.Sprintf("%s key", curve), fmt.Sprintf("Position of the center of the bbox on "+
+		"the %s curve of order %d over extent %v", curve, curveOrder, keyExtent), "", db)
+	indexName := fmt.Sprintf("%s_%s_idx", table.Name, column)
+	createIndex(table.Name, []string{column}, indexName, false, db)
+
+	report.table(table.Name).CurveKey = &CurveKeyReport{
+		Curve:  curve,
+		Order:  curveOrder,
+		Extent: keyExtent[:],
+		Column: column,
+		Index:  indexName,
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestAddCurveKey(t *testing.T) {
+	// without SpatiaLite, but with the SQL functions implemented in Go
+	registerDriver("sqlite3_with_functions", nil)
+	db, err := sql.Open("sqlite3_with_functions", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, minx numeric, maxx numeric, miny numeric, maxy numeric)", db)
+	// a feature in every quadrant of the extent (0, 0, 100, 100) and a feature without geometry
+	executeQuery("INSERT INTO pand VALUES (1, 0, 10, 0, 10), (2, 90, 100, 90, 100), (3, 0, 10, 90, 100), "+
+		"(4, 90, 100, 0, 10), (5, NULL, NULL, NULL, NULL)", db)
+
+	report := newReport("test.gpkg", "oaf")
+	addCurveKey(Table{Name: "pand", IsFeatures: true}, curveHilbert, report, db)
+	curveKeyReport := report.table("pand").CurveKey
+	if curveKeyReport == nil || curveKeyReport.Column != "hilbert_key" || curveKeyReport.Index != "pand_hilbert_key_idx" {
+		log.Fatalf("unexpected curve key report %v", curveKeyReport)
+	}
+
+	// the Hilbert curve visits lower left, upper left, upper right and lower right
+	fids := queryStrings("select fid from pand where hilbert_key is not null order by hilbert_key", db)
+	if len(fids) != 4 || fids[0] != "1" || fids[1] != "3" || fids[2] != "2" || fids[3] != "4" {
+		log.Fatalf("expected fids 1, 3, 2, 4 in order of the curve key, got %v", fids)
+	}
+}
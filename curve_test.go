@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"testing"
+)
+
+func TestCurveKey(t *testing.T) {
+	extent := [4]float64{0, 0, 100, 100}
+	// the curves visit the quadrants in a different order, the top two bits of the key are the quadrant
+	quadrants := map[string][4]int64{
+		// lower left, upper left, upper right, lower right
+		curveHilbert: {0, 1, 2, 3},
+		curveMorton:  {0, 2, 3, 1},
+	}
+	positions := [4][2]float64{{25, 25}, {25, 75}, {75, 75}, {75, 25}}
+	for curve, expected := range quadrants {
+		for i, position := range positions {
+			key, err := curveKey(curve, position[0], position[1], extent)
+			if err != nil {
+				log.Fatalf("error computing %s key: %s", curve, err)
+			}
+			if quadrant := key >> (2*curveOrder - 2); quadrant != expected[i] {
+				log.Fatalf("expected %v in quadrant %d of the %s curve, got %d", position, expected[i], curve, quadrant)
+			}
+		}
+	}
+
+	// positions outside the extent are clamped to the outermost cells
+	inside, _ := curveKey(curveHilbert, 100, 0, extent)
+	outside, _ := curveKey(curveHilbert, 150, -10, extent)
+	if inside != outside {
+		log.Fatalf("expected clamped key %d, got %d", inside, outside)
+	}
+	if _, err := curveKey("peano", 0, 0, extent); err == nil {
+		log.Fatal("expected error for unknown curve")
+	}
+	if key, err := sqlCurveKey(curveHilbert, nil, nil, 0.0, 0.0, 100.0, 100.0); key != nil || err != nil {
+		log.Fatalf("expected NULL key for NULL geometry, got %v (%v)", key, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// optimizerExtension declares the columns added by the optimizer in gpkg_extensions, so GeoPackage-aware tools
+// and conformance checkers know these columns follow the PDOK optimizer conventions
+const (
+	optimizerExtension           = "pdok_optimizer"
+	optimizerExtensionDefinition = provenanceURI + "#optimizations"
+	schemaExtensionDefinition    = "http://www.geopackage.org/spec/#extension_schema"
+)
+
+// uuidConstraint restricts a column to lowercase UUIDs, e.g. external_fid
+const uuidConstraint = "pdok_uuid"
+
+var uuidGlob = strings.Join([]string{
+	strings.Repeat("[0-9a-f]", 8),
+	strings.Repeat("[0-9a-f]", 4),
+	strings.Repeat("[0-9a-f]", 4),
+	strings.Repeat("[0-9a-f]", 4),
+	strings.Repeat("[0-9a-f]", 12),
+}, "-")
+
+// describeColumn registers a column added by the optimizer in gpkg_data_columns (schema extension) with a title,
+// description and optional constraint, and declares it in gpkg_extensions as part of the PDOK optimizer extension
+func describeColumn(tableName string, columnName string, title string, description string, constraintName string, db *sql.DB) {
+	if !tableExists("gpkg_data_columns", db) {
+		executeQuery("CREATE TABLE gpkg_data_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, name TEXT, "+
+			"title TEXT, description TEXT, mime_type TEXT, constraint_name TEXT, "+
+			"CONSTRAINT pk_gdc PRIMARY KEY (table_name, column_name), CONSTRAINT gdc_tn UNIQUE (table_name, name))", db)
+		recordArtifact("", artifactTable, "gpkg_data_columns", db)
+	}
+	registerExtension("gpkg_data_columns", "", "gpkg_schema", schemaExtensionDefinition, "read-write", db)
+	if constraintName == uuidConstraint {
+		addUUIDConstraint(db)
+	}
+
+	_, err := db.Exec("insert or replace into gpkg_data_columns (table_name, column_name, name, title, description, mime_type, constraint_name) "+
+		"values (?, ?, ?, ?, ?, null, ?)", tableName, columnName, columnName, title, description, nullIfEmpty(constraintName))
+	if err != nil {
+		log.Fatalf("error registering column '%s' in gpkg_data_columns: %s", columnName, err)
+	}
+	registerExtension(tableName, columnName, optimizerExtension, optimizerExtensionDefinition, "read-write", db)
+}
+
+// describeBBoxColumns describes the bbox columns with the given prefix, of the given geometry
+func describeBBoxColumns(tableName string, prefix string, geometry string, db *sql.DB) {
+	for _, component := range []string{"minx", "maxx", "miny", "maxy"} {
+		describeColumn(tableName, prefix+component, fmt.Sprintf("%s of bbox", component),
+			fmt.Sprintf("%s of the bounding box of %s", strings.ToUpper(component[:1])+component[1:], geometry), "", db)
+	}
+}
+
+func addUUIDConstraint(db *sql.DB) {
+	if !tableExists("gpkg_data_column_constraints", db) {
+		executeQuery("CREATE TABLE gpkg_data_column_constraints (constraint_name TEXT NOT NULL, constraint_type TEXT NOT NULL, "+
+			"value TEXT, min NUMERIC, min_is_inclusive BOOLEAN, max NUMERIC, max_is_inclusive BOOLEAN, description TEXT, "+
+			"CONSTRAINT gdcc_ntv UNIQUE (constraint_name, constraint_type, value))", db)
+		recordArtifact("", artifactTable, "gpkg_data_column_constraints", db)
+	}
+	registerExtension("gpkg_data_column_constraints", "", "gpkg_schema", schemaExtensionDefinition, "read-write", db)
+
+	var exists int
+	err := db.QueryRow("select exists(select 1 from gpkg_data_column_constraints where constraint_name = ?)", uuidConstraint).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error reading gpkg_data_column_constraints: %s", err)
+	}
+	if exists == 1 {
+		return
+	}
+	_, err = db.Exec("insert into gpkg_data_column_constraints (constraint_name, constraint_type, value, description) values (?, 'glob', ?, ?)",
+		uuidConstraint, uuidGlob, "UUID in lowercase hexadecimal notation")
+	if err != nil {
+		log.Fatalf("error adding constraint '%s': %s", uuidConstraint, err)
+	}
+	recordArtifact("", artifactDataColumnConstraint, uuidConstraint, db)
+}
+
+// forgetColumn removes the registrations of a removed column from gpkg_data_columns and gpkg_extensions
+func forgetColumn(tableName string, columnName string, db *sql.DB) {
+	for _, registry := range []string{"gpkg_data_columns", "gpkg_extensions"} {
+		if !tableExists(registry, db) {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf("delete from %s where table_name = ? and column_name = ?", registry), tableName, columnName)
+		if err != nil {
+			log.Fatalf("error removing column '%s' from %s: %s", columnName, registry, err)
+		}
+	}
+}
+
+func revertDataColumnConstraint(constraintName string, db *sql.DB) {
+	if !tableExists("gpkg_data_column_constraints", db) {
+		return
+	}
+	_, err := db.Exec("delete from gpkg_data_column_constraints where constraint_name = ?", constraintName)
+	if err != nil {
+		log.Fatalf("error removing constraint '%s': %s", constraintName, err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestDescribeColumn(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, identificatie TEXT)", db)
+	addColumn("pand", "external_fid", "TEXT", db)
+	describeColumn("pand", "external_fid", "External feature id", "Stable identifier of the feature", uuidConstraint, db)
+
+	var title, constraintName string
+	err = db.QueryRow("select title, constraint_name from gpkg_data_columns where table_name = 'pand' and column_name = 'external_fid'").
+		Scan(&title, &constraintName)
+	if err != nil || title != "External feature id" || constraintName != uuidConstraint {
+		log.Fatalf("expected external_fid in gpkg_data_columns, got '%s', '%s' (%v)", title, constraintName, err)
+	}
+	extensions := queryStrings("select extension_name from gpkg_extensions where table_name = 'pand' and column_name = 'external_fid'", db)
+	if len(extensions) != 1 || extensions[0] != optimizerExtension {
+		log.Fatalf("expected external_fid in gpkg_extensions, got %v", extensions)
+	}
+	var matches int
+	err = db.QueryRow("select '0b5a9f6e-4a8e-5c1d-9e3f-2a7b8c9d0e1f' glob value from gpkg_data_column_constraints where constraint_name = ?",
+		uuidConstraint).Scan(&matches)
+	if err != nil || matches != 1 {
+		log.Fatalf("expected uuid to match constraint '%s' (%v)", uuidConstraint, err)
+	}
+
+	report := &ConformanceReport{Passed: true}
+	checkExtensions(report, db)
+	checkDataColumns(report, db)
+	if !report.Passed {
+		log.Fatalf("expected described column to conform, got %v", report.Checks)
+	}
+
+	revertArtifacts(db)
+	for _, table := range []string{"gpkg_data_columns", "gpkg_data_column_constraints", "gpkg_extensions"} {
+		if tableExists(table, db) {
+			log.Fatalf("expected table '%s' to be removed", table)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+)
+
+// registerExtension registers an extension of a column, a table (when columnName is empty) or the whole GeoPackage
+// (when tableName is empty too) in gpkg_extensions, creating gpkg_extensions when missing. Column extensions are not
+// recorded as artifact, since these are removed together with the column.
+func registerExtension(tableName string, columnName string, extensionName string, definition string, scope string, db *sql.DB) {
+	if !tableExists("gpkg_extensions", db) {
+		executeQuery("CREATE TABLE gpkg_extensions (table_name TEXT, column_name TEXT, extension_name TEXT NOT NULL, "+
+			"definition TEXT NOT NULL, scope TEXT NOT NULL, CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name))", db)
+		recordArtifact("", artifactTable, "gpkg_extensions", db)
+	}
+	var exists int
+	err := db.QueryRow("select exists(select 1 from gpkg_extensions where table_name is ? and column_name is ? and extension_name = ?)",
+		nullIfEmpty(tableName), nullIfEmpty(columnName), extensionName).Scan(&exists)
+	if err != nil {
+		log.Fatalf("error reading gpkg_extensions: %s", err)
+	}
+	if exists == 1 {
+		return
+	}
+	_, err = db.Exec("insert into gpkg_extensions(table_name, column_name, extension_name, definition, scope) values (?, ?, ?, ?, ?)",
+		nullIfEmpty(tableName), nullIfEmpty(columnName), extensionName, definition, scope)
+	if err != nil {
+		log.Fatalf("error registering extension '%s': %s", extensionName, err)
+	}
+	if columnName == "" {
+		recordArtifact(tableName, artifactExtension, extensionName, db)
+	}
+}
+
+// unregisterExtension removes a table or GeoPackage extension from gpkg_extensions
+func unregisterExtension(tableName string, extensionName string, db *sql.DB) {
+	if !tableExists("gpkg_extensions", db) {
+		return
+	}
+	_, err := db.Exec("delete from gpkg_extensions where table_name is ? and column_name is null and extension_name = ?",
+		nullIfEmpty(tableName), extensionName)
+	if err != nil {
+		log.Fatalf("error removing extension '%s' from gpkg_extensions: %s", extensionName, err)
+	}
+}
+
+func nullIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
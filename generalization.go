@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// addGeneralizedGeometryColumns computes a simplified version of the primary geometry of the given table for every
+// configured generalization level, so features can be served efficiently at smaller scales. The simplified geometries
+// are stored in a companion table <table>_<geometry column>_<name> per level, which gets its own bbox columns and
+// spatial index, like the table itself.
+func addGeneralizedGeometryColumns(table Table, layerCfg Layer, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		return
+	}
+	source, ok := table.geometryColumn(layerCfg.GeomColumn)
+	if !ok {
+		log.Fatalf("cannot determine type of geometry column '%s' of table '%s'", layerCfg.GeomColumn, table.Name)
+	}
+
+	for _, generalization := range layerCfg.Generalizations {
+		if generalization.Tolerance <= 0 {
+			log.Fatalf("generalization of table '%s' must have a positive tolerance", table.Name)
+		}
+		tolerance := strconv.FormatFloat(generalization.Tolerance, 'f', -1, 64)
+		companionName := fmt.Sprintf("%s_%s_%s", table.Name, source.Name, generalization.name())
+		companion := createCompanionTable(table, layerCfg.FidColumn, companionName, source,
+			fmt.Sprintf("AsGPB(ST_SimplifyPreserveTopology(GeomFromGPB(\"%s\"), %s))", source.Name, tolerance),
+			fmt.Sprintf("Geometry column '%s' of table '%s' simplified with a tolerance of %s", source.Name, table.Name, tolerance), db)
+
+		report.table(table.Name).Generalizations = append(report.table(table.Name).Generalizations, GeneralizationReport{
+			Tolerance: generalization.Tolerance,
+			Table:     companion.Name,
+			Column:    source.Name,
+			Index:     fmt.Sprintf("%s_spatial_idx", companion.Name),
+		})
+	}
+}
+
+// name returns the configured name of the generalization level, or a name based on its tolerance
+func (g Generalization) name() string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return "simplified_" + strings.ReplaceAll(strconv.FormatFloat(g.Tolerance, 'f', -1, 64), ".", "_")
+}
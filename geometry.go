@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// GeoPackage binary geometry encoding, see http://www.geopackage.org/spec/#gpb_format
+const (
+	gpbMagic            = "GP"
+	gpbVersion          = 0
+	gpbFlagLittleEndian = 0x01
+	gpbFlagEmpty        = 0x10
+	gpbFlagExtended     = 0x20
+)
+
+// geometry type names as used in gpkg_geometry_columns, indexed by WKB geometry type code
+var geometryTypeNames = map[uint32]string{
+	0:  "GEOMETRY",
+	1:  "POINT",
+	2:  "LINESTRING",
+	3:  "POLYGON",
+	4:  "MULTIPOINT",
+	5:  "MULTILINESTRING",
+	6:  "MULTIPOLYGON",
+	7:  "GEOMETRYCOLLECTION",
+	8:  "CIRCULARSTRING",
+	9:  "COMPOUNDCURVE",
+	10: "CURVEPOLYGON",
+	11: "MULTICURVE",
+	12: "MULTISURFACE",
+	13: "CURVE",
+	14: "SURFACE",
+}
+
+type GeometryHeader struct {
+	SrsID    int32
+	Empty    bool
+	Extended bool
+	Envelope []float64
+	// WKB geometry type, without Z/M
+	GeometryType uint32
+	HasZ         bool
+	HasM         bool
+	// offset of the WKB geometry in the blob
+	WKBOffset int
+}
+
+// parseGeometryHeader parses the header of a GeoPackage binary geometry, including the type of the WKB geometry
+func parseGeometryHeader(blob []byte) (GeometryHeader, error) {
+	var header GeometryHeader
+	if len(blob) < 8 || string(blob[0:2]) != gpbMagic {
+		return header, errors.New("missing GeoPackage binary magic number")
+	}
+	if blob[2] != gpbVersion {
+		return header, fmt.Errorf("unsupported GeoPackage binary version %d", blob[2])
+	}
+	flags := blob[3]
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	if flags&gpbFlagLittleEndian != 0 {
+		byteOrder = binary.LittleEndian
+	}
+	header.SrsID = int32(byteOrder.Uint32(blob[4:8]))
+	header.Empty = flags&gpbFlagEmpty != 0
+	header.Extended = flags&gpbFlagExtended != 0
+
+	envelopeSize := map[byte]int{0: 0, 1: 4, 2: 6, 3: 6, 4: 8}
+	envelopeIndicator := (flags >> 1) & 0x07
+	size, ok := envelopeSize[envelopeIndicator]
+	if !ok {
+		return header, fmt.Errorf("invalid envelope indicator %d", envelopeIndicator)
+	}
+	header.WKBOffset = 8 + size*8
+	if len(blob) < header.WKBOffset+5 {
+		return header, errors.New("GeoPackage binary geometry is truncated")
+	}
+	for i := 0; i < size; i++ {
+		header.Envelope = append(header.Envelope, math.Float64frombits(byteOrder.Uint64(blob[8+i*8:])))
+	}
+
+	if header.Extended {
+		// extended geometries have an extension specific encoding
+		return header, nil
+	}
+	var wkbByteOrder binary.ByteOrder = binary.BigEndian
+	if blob[header.WKBOffset] == 1 {
+		wkbByteOrder = binary.LittleEndian
+	}
+	wkbType := wkbByteOrder.Uint32(blob[header.WKBOffset+1:])
+	header.GeometryType = wkbType % 1000
+	switch wkbType / 1000 {
+	case 1:
+		header.HasZ = true
+	case 2:
+		header.HasM = true
+	case 3:
+		header.HasZ, header.HasM = true, true
+	}
+	return header, nil
+}
+
+// geometryTypeMatches returns whether a geometry of the given type may be stored in a column of the declared type
+func geometryTypeMatches(declaredType string, geometryType uint32) bool {
+	switch declaredType {
+	case "GEOMETRY":
+		return true
+	case "GEOMETRYCOLLECTION":
+		return geometryType == 7 || (geometryType >= 4 && geometryType <= 6) || geometryType == 11 || geometryType == 12
+	case "MULTICURVE":
+		return geometryType == 11 || geometryType == 5
+	case "MULTISURFACE":
+		return geometryType == 12 || geometryType == 6
+	case "CURVE":
+		return geometryType == 13 || geometryType == 2 || geometryType == 8 || geometryType == 9
+	case "SURFACE":
+		return geometryType == 14 || geometryType == 3 || geometryType == 10
+	case "CURVEPOLYGON":
+		return geometryType == 10 || geometryType == 3
+	case "COMPOUNDCURVE":
+		return geometryType == 9
+	default:
+		return geometryTypeNames[geometryType] == declaredType
+	}
+}
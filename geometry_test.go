@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"testing"
+)
+
+func TestParseGeometryHeader(t *testing.T) {
+	// little endian, envelope [minx, maxx, miny, maxy], srs_id 28992, WKB Point Z
+	blob := []byte{'G', 'P', 0, 0x03}
+	blob = binary.LittleEndian.AppendUint32(blob, 28992)
+	for _, value := range []float64{1, 2, 3, 4} {
+		blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(value))
+	}
+	blob = append(blob, 1)
+	blob = binary.LittleEndian.AppendUint32(blob, 1001)
+	for _, value := range []float64{1, 3, 5} {
+		blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(value))
+	}
+
+	header, err := parseGeometryHeader(blob)
+	if err != nil {
+		log.Fatalf("error parsing geometry header: %s", err)
+	}
+	if header.SrsID != 28992 || len(header.Envelope) != 4 || header.Envelope[3] != 4 || header.WKBOffset != 40 {
+		log.Fatalf("unexpected geometry header: %+v", header)
+	}
+	if header.GeometryType != 1 || !header.HasZ || header.HasM {
+		log.Fatalf("expected Point Z, got %+v", header)
+	}
+	if !geometryTypeMatches("GEOMETRY", header.GeometryType) || geometryTypeMatches("POLYGON", header.GeometryType) {
+		log.Fatal("unexpected geometry type match")
+	}
+
+	if _, err = parseGeometryHeader([]byte("not a geometry")); err == nil {
+		log.Fatal("expected error for invalid geometry blob")
+	}
+}
+
+func TestDecodeGeometry(t *testing.T) {
+	// little endian, no envelope, srs_id 28992, WKB MultiPolygon with a Polygon of one ring
+	blob := []byte{'G', 'P', 0, 0x01}
+	blob = binary.LittleEndian.AppendUint32(blob, 28992)
+	blob = append(blob, 1)
+	blob = binary.LittleEndian.AppendUint32(blob, 6)
+	blob = binary.LittleEndian.AppendUint32(blob, 1)
+	blob = append(blob, 1)
+	blob = binary.LittleEndian.AppendUint32(blob, 3)
+	blob = binary.LittleEndian.AppendUint32(blob, 1)
+	blob = binary.LittleEndian.AppendUint32(blob, 4)
+	for _, value := range []float64{0, 0, 10, 0, 10, 10, 0, 0} {
+		blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(value))
+	}
+
+	geometry, err := decodeGeometry(blob)
+	if err != nil {
+		log.Fatalf("error decoding geometry: %s", err)
+	}
+	if len(geometry.Polygons) != 1 || len(geometry.Polygons[0]) != 1 || geometry.Polygons[0][0][2] != [2]float64{10, 10} {
+		log.Fatalf("unexpected geometry: %+v", geometry)
+	}
+
+	if _, err = decodeGeometry(blob[:len(blob)-8]); err == nil {
+		log.Fatal("expected error for truncated geometry")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// writeGoKoalaConfig writes a GoKoala (OGC API Features) config snippet for the tables in the report of an OAF
+// optimization: a GeoPackage datasource and a collection per table, including the external fid, temporal properties,
+// relations and extent. The snippet is a starting point, metadata like titles and descriptions still needs to be added.
+func writeGoKoalaConfig(report *Report, path string) {
+	log.Printf("Writing GoKoala config to '%s'", path)
+	err := os.WriteFile(path, []byte(goKoalaConfig(report)), 0644)
+	if err != nil {
+		log.Fatalf("cannot write '%s': %s", path, err)
+	}
+}
+
+func goKoalaConfig(report *Report) string {
+	var tableNames []string
+	for tableName, tableReport := range report.Tables {
+		if tableReport.FidColumn != "" {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+	if len(tableNames) == 0 {
+		log.Printf("WARNING: no OAF optimized tables in report, GoKoala config contains no collections")
+	}
+
+	// GoKoala supports a single fid column per datasource, and a datasource per CRS
+	fid, externalFid, srsID := "", "", int64(0)
+	for _, tableName := range tableNames {
+		tableReport := report.Tables[tableName]
+		if fid == "" {
+			fid = tableReport.FidColumn
+		} else if fid != tableReport.FidColumn {
+			log.Printf("WARNING: table '%s' has fid column '%s' instead of '%s', which is not supported by GoKoala", tableName, tableReport.FidColumn, fid)
+		}
+		if externalFid == "" {
+			externalFid = tableReport.ExternalFid
+		}
+		if srsID == 0 {
+			srsID = tableReport.SrsID
+		} else if tableReport.SrsID != 0 && srsID != tableReport.SrsID {
+			log.Printf("WARNING: table '%s' has CRS EPSG:%d instead of EPSG:%d, which needs a separate GoKoala datasource", tableName, tableReport.SrsID, srsID)
+		}
+	}
+
+	var yaml strings.Builder
+	line := func(indent int, format string, args ...any) {
+		yaml.WriteString(strings.Repeat("  ", indent))
+		yaml.WriteString(fmt.Sprintf(format, args...))
+		yaml.WriteString("\n")
+	}
+	line(0, "# GoKoala config generated by geopackage-optimizer-go for %s", filepath.Base(report.Geopackage))
+	line(0, "ogcApi:")
+	line(1, "features:")
+	line(2, "datasources:")
+	geopackage := func(indent int) {
+		line(indent, "geopackage:")
+		line(indent+1, "local:")
+		line(indent+2, "file: %s", strconv.Quote(filepath.Base(report.Geopackage)))
+		if fid != "" {
+			line(indent+2, "fid: %s", strconv.Quote(fid))
+		}
+		if externalFid != "" {
+			line(indent+2, "externalFid: %s", strconv.Quote(externalFid))
+		}
+	}
+	// a native CRS other than WGS84 is an additional datasource, the defaultWGS84 datasource (required by GoKoala)
+	// must then refer to a copy of the geopackage in WGS84, which the optimizer doesn't create
+	if srsID == 0 || srsID == epsgWGS84 {
+		line(3, "defaultWGS84:")
+		geopackage(4)
+	} else {
+		log.Printf("WARNING: the geometries are in EPSG:%d, add a defaultWGS84 datasource with a copy of the geopackage "+
+			"in EPSG:%d to the GoKoala config", srsID, epsgWGS84)
+		line(3, "additional:")
+		line(4, "- srs: %s", strconv.Quote(fmt.Sprintf("EPSG:%d", srsID)))
+		geopackage(5)
+	}
+
+	line(2, "collections:")
+	for _, tableName := range tableNames {
+		tableReport := report.Tables[tableName]
+		line(3, "- id: %s", strconv.Quote(tableName))
+		line(4, "metadata:")
+		line(5, "title: %s", strconv.Quote(tableName))
+		if len(tableReport.TemporalColumns) > 0 {
+			line(5, "temporalProperties:")
+			line(6, "startDate: %s", strconv.Quote(tableReport.TemporalColumns[0]))
+			if len(tableReport.TemporalColumns) > 1 {
+				line(6, "endDate: %s", strconv.Quote(tableReport.TemporalColumns[1]))
+			}
+		}
+		if len(tableReport.Extent) == 4 {
+			line(5, "extent:")
+			line(6, "srs: %s", strconv.Quote(fmt.Sprintf("EPSG:%d", tableReport.SrsID)))
+			var bbox []string
+			for _, coordinate := range tableReport.Extent {
+				bbox = append(bbox, strconv.Quote(strconv.FormatFloat(coordinate, 'f', -1, 64)))
+			}
+			line(6, "bbox: [%s]", strings.Join(bbox, ", "))
+		}
+		line(4, "features:")
+		line(5, "tableName: %s", strconv.Quote(tableName))
+		if len(tableReport.Relations) > 0 {
+			line(5, "relations:")
+			for _, relation := range tableReport.Relations {
+				line(6, "- collection: %s", strconv.Quote(relation.Table))
+				line(7, "column: %s", strconv.Quote(relation.Column))
+			}
+		}
+	}
+	return yaml.String()
+}
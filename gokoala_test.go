@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestGoKoalaConfig(t *testing.T) {
+	report := newReport("testdata/addresses.gpkg", "oaf")
+	report.table("addresses").layer(Table{Name: "addresses", GeometryColumns: []GeometryColumn{{Name: "geom", SrsID: epsgRDNew}}},
+		Layer{FidColumn: "fid", GeomColumn: "geom", TemporalColumns: []string{"valid_from", "valid_to"}})
+	report.table("addresses").ExternalFid = "external_fid"
+	report.table("addresses").Extent = []float64{10000, 300000, 280000, 625000.5}
+	report.table("addresses").Relations = []RelationReport{{Table: "buildings", Column: "buildings_external_fid"}}
+	report.table("layer_styles").FeatureCount = 1
+
+	config := goKoalaConfig(report)
+	for _, expected := range []string{
+		"    datasources:\n      additional:\n        - srs: \"EPSG:28992\"\n          geopackage:\n            local:\n              file: \"addresses.gpkg\"\n" +
+			"              fid: \"fid\"\n              externalFid: \"external_fid\"\n",
+		"    collections:\n      - id: \"addresses\"\n",
+		"          temporalProperties:\n            startDate: \"valid_from\"\n            endDate: \"valid_to\"\n",
+		"            srs: \"EPSG:28992\"\n            bbox: [\"10000\", \"300000\", \"280000\", \"625000.5\"]\n",
+		"          tableName: \"addresses\"\n          relations:\n            - collection: \"buildings\"\n              column: \"buildings_external_fid\"\n",
+	} {
+		if !strings.Contains(config, expected) {
+			log.Fatalf("expected GoKoala config to contain\n%s\ngot\n%s", expected, config)
+		}
+	}
+	if strings.Contains(config, "layer_styles") {
+		log.Fatalf("expected only OAF optimized tables in GoKoala config, got\n%s", config)
+	}
+	// the geopackage is not in WGS84, so it can't be the defaultWGS84 datasource
+	if strings.Contains(config, "defaultWGS84") {
+		log.Fatalf("expected no defaultWGS84 datasource for geometries in EPSG:28992, got\n%s", config)
+	}
+}
+
+func TestGoKoalaConfigWGS84(t *testing.T) {
+	report := newReport("testdata/addresses.gpkg", "oaf")
+	report.table("addresses").layer(Table{Name: "addresses", GeometryColumns: []GeometryColumn{{Name: "geom", SrsID: epsgWGS84}}},
+		Layer{FidColumn: "fid", GeomColumn: "geom"})
+
+	config := goKoalaConfig(report)
+	if !strings.Contains(config, "      defaultWGS84:\n        geopackage:\n") || strings.Contains(config, "additional:") {
+		log.Fatalf("expected only a WGS84 datasource, got\n%s", config)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+)
+
+// columns and indexes added by the optimizer, recognized by name in GeoPackages without recorded artifacts (optimized
+// before artifacts were recorded)
+var (
+	optimizerColumnPattern = regexp.MustCompile(`^(puuid|fuuid|external_fid|label_x|label_y|label_point|original_fid|hilbert_key|morton_key|.*_external_fid|(.*_)?(minx|maxx|miny|maxy)|.*_epsg\d+|.*_simplified_.*|.*_z\d+|.*_tile_(min|max)_[xy])$`)
+	optimizerIndexPattern  = regexp.MustCompile(`_(spatial_idx|temporal_idx|external_fid_idx|queryable_idx|label_idx|tile_idx|original_fid_idx|hilbert_key_idx|morton_key_idx|puuid_index|fuuid_index)$`)
+)
+
+type Inspection struct {
+	Geopackage string            `json:"geopackage"`
+	Tables     []TableInspection `json:"tables"`
+	Artifacts  []Artifact        `json:"artifacts,omitempty"`
+	Provenance *Provenance       `json:"provenance,omitempty"`
+}
+
+type TableInspection struct {
+	Name             string            `json:"name"`
+	DataType         string            `json:"data-type"`
+	Columns          []Column          `json:"columns"`
+	GeometryColumns  []GeometryColumn  `json:"geometry-columns,omitempty"`
+	Indexes          []IndexInspection `json:"indexes,omitempty"`
+	OptimizerColumns []string          `json:"optimizer-columns,omitempty"`
+	OptimizerIndexes []string          `json:"optimizer-indexes,omitempty"`
+	SearchTable      string            `json:"search-table,omitempty"`
+}
+
+type IndexInspection struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+	Columns []string `json:"columns"`
+}
+
+// inspectGeopackage describes the tables in gpkg_contents, including the columns and indexes added by the optimizer
+func inspectGeopackage(sourceGeopackage string) *Inspection {
+	log.Printf("Inspecting geopackage: '%s'...\n", sourceGeopackage)
+	db := openDb(sourceGeopackage)
+	defer db.Close()
+
+	inspection := &Inspection{Geopackage: sourceGeopackage, Artifacts: readArtifacts(db)}
+	if provenance, ok := readLatestProvenance(db); ok {
+		inspection.Provenance = &provenance
+	}
+	for _, table := range readTables(db) {
+		tableInspection := TableInspection{
+			Name:            table.Name,
+			DataType:        table.DataType,
+			Columns:         readColumns(table.Name, db),
+			GeometryColumns: table.GeometryColumns,
+			Indexes:         readIndexes(table.Name, db),
+		}
+		inspectOptimizerArtifacts(&tableInspection, inspection.Artifacts, tableExists(artifactsTable, db))
+		if tableExists(table.Name+"_search", db) {
+			tableInspection.SearchTable = table.Name + "_search"
+		}
+		inspection.Tables = append(inspection.Tables, tableInspection)
+	}
+	return inspection
+}
+
+// inspectOptimizerArtifacts determines the columns and indexes of the given table that were added by the optimizer, using
+// the recorded artifacts. Only GeoPackages without an artifacts table fall back to recognizing these by name.
+func inspectOptimizerArtifacts(tableInspection *TableInspection, artifacts []Artifact, recorded bool) {
+	columns, indexes := make(map[string]bool), make(map[string]bool)
+	for _, artifact := range artifacts {
+		if artifact.TableName != tableInspection.Name {
+			continue
+		}
+		switch artifact.Type {
+		case artifactColumn:
+			columns[artifact.Name] = true
+		case artifactIndex:
+			indexes[artifact.Name] = true
+		}
+	}
+	for _, column := range tableInspection.Columns {
+		if columns[column.Name] || (!recorded && optimizerColumnPattern.MatchString(column.Name)) {
+			tableInspection.OptimizerColumns = append(tableInspection.OptimizerColumns, column.Name)
+		}
+	}
+	for _, index := range tableInspection.Indexes {
+		if indexes[index.Name] || (!recorded && optimizerIndexPattern.MatchString(index.Name)) {
+			tableInspection.OptimizerIndexes = append(tableInspection.OptimizerIndexes, index.Name)
+		}
+	}
+}
+
+// readIndexes returns the explicitly created indexes of the given table
+func readIndexes(tableName string, db *sql.DB) []IndexInspection {
+	names := queryStrings("select name from pragma_index_list(?) where origin = 'c' order by name", db, tableName)
+	var result []IndexInspection
+	for _, name := range names {
+		index := IndexInspection{Name: name}
+		err := db.QueryRow(`select "unique" from pragma_index_list(?) where name = ?`, tableName, name).Scan(&index.Unique)
+		if err != nil {
+			log.Fatalf("error reading index '%s': %s", name, err)
+		}
+		index.Columns = queryStrings("select coalesce(name, '<expression>') from pragma_index_info(?) order by seqno", db, name)
+		result = append(result, index)
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestReadIndexes(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, naam TEXT UNIQUE, minx, maxx, miny, maxy)", db)
+	createIndex("pand", []string{"fid", "minx", "maxx", "miny", "maxy"}, "pand_spatial_idx", false, db)
+	createIndex("pand", []string{"lower(naam)"}, "pand_naam_idx", true, db)
+
+	indexes := readIndexes("pand", db)
+	if len(indexes) != 2 {
+		log.Fatalf("expected 2 explicitly created indexes, got %v", indexes)
+	}
+	if indexes[0].Name != "pand_naam_idx" || !indexes[0].Unique || indexes[0].Columns[0] != "<expression>" {
+		log.Fatalf("unexpected index: %v", indexes[0])
+	}
+	if indexes[1].Name != "pand_spatial_idx" || len(indexes[1].Columns) != 5 || !optimizerIndexPattern.MatchString(indexes[1].Name) {
+		log.Fatalf("unexpected index: %v", indexes[1])
+	}
+
+	// table and index names are bound as parameters, so quotes in names don't break the query
+	executeQuery(`CREATE TABLE "pand's" (fid INTEGER PRIMARY KEY, naam TEXT)`, db)
+	executeQuery(`CREATE INDEX "pand's_naam_idx" ON "pand's" (naam)`, db)
+	if indexes = readIndexes("pand's", db); len(indexes) != 1 || indexes[0].Columns[0] != "naam" {
+		log.Fatalf("expected index on table with a quote in its name, got %v", indexes)
+	}
+}
+
+func TestInspectOptimizerArtifacts(t *testing.T) {
+	tableInspection := TableInspection{
+		Name:    "pand",
+		Columns: []Column{{Name: "fid"}, {Name: "minx"}, {Name: "bouwjaar_z2"}, {Name: "label_x"}},
+		Indexes: []IndexInspection{{Name: "pand_spatial_idx"}, {Name: "pand_label_idx"}},
+	}
+	artifacts := []Artifact{
+		{TableName: "pand", Type: artifactIndex, Name: "pand_label_idx"},
+		{TableName: "pand", Type: artifactColumn, Name: "label_x"},
+		{TableName: "verblijfsobject", Type: artifactColumn, Name: "minx"},
+	}
+
+	// recorded artifacts are used, even if a column or index name looks like an optimizer column or index
+	recorded := tableInspection
+	inspectOptimizerArtifacts(&recorded, artifacts, true)
+	if strings.Join(recorded.OptimizerColumns, ",") != "label_x" || strings.Join(recorded.OptimizerIndexes, ",") != "pand_label_idx" {
+		log.Fatalf("expected the recorded artifacts, got %v and %v", recorded.OptimizerColumns, recorded.OptimizerIndexes)
+	}
+
+	// without an artifacts table, optimizer columns and indexes are recognized by name
+	unrecorded := tableInspection
+	inspectOptimizerArtifacts(&unrecorded, nil, false)
+	if strings.Join(unrecorded.OptimizerColumns, ",") != "minx,bouwjaar_z2,label_x" ||
+		strings.Join(unrecorded.OptimizerIndexes, ",") != "pand_spatial_idx,pand_label_idx" {
+		log.Fatalf("expected the columns and indexes recognized by name, got %v and %v", unrecorded.OptimizerColumns, unrecorded.OptimizerIndexes)
+	}
+}
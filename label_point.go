@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+const (
+	labelXColumn = "label_x"
+	labelYColumn = "label_y"
+)
+
+// addLabelPoint adds the coordinates of a representative point of the given geometry column, which is guaranteed to
+// lie inside the geometry (unlike the centroid). Useful for labels, clustering and centroid-based filtering.
+// Optionally the label point is also added as point geometry, in a companion table <table>_label_point.
+func addLabelPoint(table Table, fidColumn, geomColumn string, labelPoint LabelPoint, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		log.Printf("Skipping label point for table '%s' because it is not of type 'features'", table.Name)
+		return
+	}
+	source, ok := table.geometryColumn(geomColumn)
+	if !ok {
+		log.Fatalf("cannot determine CRS of geometry column '%s' of table '%s'", geomColumn, table.Name)
+	}
+
+	pointOnSurface := fmt.Sprintf("ST_PointOnSurface(GeomFromGPB(%s))", geomColumn)
+	addColumn(table.Name, labelXColumn, "numeric", db)
+	addColumn(table.Name, labelYColumn, "numeric", db)
+	setColumnValue(table.Name, labelXColumn, fmt.Sprintf("ST_X(%s)", pointOnSurface), db)
+	setColumnValue(table.Name, labelYColumn, fmt.Sprintf("ST_Y(%s)", pointOnSurface), db)
+	describeColumn(table.Name, labelXColumn, "Label x", fmt.Sprintf("X of a point inside geometry column '%s', for labels", geomColumn), "", db)
+	describeColumn(table.Name, labelYColumn, "Label y", fmt.Sprintf("Y of a point inside geometry column '%s', for labels", geomColumn), "", db)
+	indexName := fmt.Sprintf("%s_label_idx", table.Name)
+	createIndex(table.Name, []string{labelXColumn, labelYColumn}, indexName, false, db)
+
+	labelPointReport := &LabelPointReport{X: labelXColumn, Y: labelYColumn, Index: indexName}
+	if labelPoint.Geometry {
+		companion := createCompanionTable(table, fidColumn, fmt.Sprintf("%s_label_point", table.Name),
+			GeometryColumn{Name: source.Name, GeometryType: "POINT", SrsID: source.SrsID},
+			fmt.Sprintf("AsGPB(%s)", pointOnSurface),
+			fmt.Sprintf("Point inside geometry column '%s' of table '%s', for labels", geomColumn, table.Name), db)
+		labelPointReport.Table = companion.Name
+		labelPointReport.Geometry = source.Name
+	}
+	report.table(table.Name).LabelPoint = labelPointReport
+}
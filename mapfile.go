@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// feature id of OWS optimized tables, as used by MapServer
+const owsFeatureID = "fuuid"
+
+// mapServerLayerTypes maps GeoPackage geometry types to MapServer layer types
+var mapServerLayerTypes = map[string]string{
+	"POINT":           "POINT",
+	"MULTIPOINT":      "POINT",
+	"LINESTRING":      "LINE",
+	"MULTILINESTRING": "LINE",
+	"CURVE":           "LINE",
+	"MULTICURVE":      "LINE",
+	"COMPOUNDCURVE":   "LINE",
+	"CIRCULARSTRING":  "LINE",
+	"POLYGON":         "POLYGON",
+	"MULTIPOLYGON":    "POLYGON",
+	"SURFACE":         "POLYGON",
+	"MULTISURFACE":    "POLYGON",
+	"CURVEPOLYGON":    "POLYGON",
+}
+
+// reportOWSLayer records the geometry column, CRS and extent of the given table, as needed for a mapfile layer
+func reportOWSLayer(table Table, report *Report, db *sql.DB) {
+	if !table.IsFeatures || len(table.GeometryColumns) == 0 {
+		return
+	}
+	tableReport := report.table(table.Name)
+	geomColumn := table.GeometryColumns[0]
+	tableReport.GeomColumn = geomColumn.Name
+	tableReport.GeometryType = geomColumn.GeometryType
+	tableReport.SrsID = geomColumn.SrsID
+
+	var organization sql.NullString
+	var coordsysID sql.NullInt64
+	err := db.QueryRow("select organization, organization_coordsys_id from gpkg_spatial_ref_sys where srs_id = ?", geomColumn.SrsID).
+		Scan(&organization, &coordsysID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Fatalf("error reading gpkg_spatial_ref_sys: %s", err)
+	}
+	if organization.Valid && coordsysID.Valid {
+		tableReport.CRS = fmt.Sprintf("%s:%d", strings.ToUpper(organization.String), coordsysID.Int64)
+	}
+
+	var extent [4]sql.NullFloat64
+	err = db.QueryRow("select min_x, min_y, max_x, max_y from gpkg_contents where table_name = ?", table.Name).
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("error reading extent of table '%s': %s", table.Name, err)
+	}
+	if extent[0].Valid && extent[1].Valid && extent[2].Valid && extent[3].Valid {
+		tableReport.Extent = []float64{extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64}
+	}
+}
+
+// writeMapfile writes a MapServer LAYER block for every feature table in the report of an OWS optimization,
+// using fuuid as feature id. The layers are a skeleton, a CLASS with styling still needs to be added.
+func writeMapfile(report *Report, path string) {
+	log.Printf("Writing mapfile layers to '%s'", path)
+	err := os.WriteFile(path, []byte(mapfileLayers(report)), 0644)
+	if err != nil {
+		log.Fatalf("cannot write '%s': %s", path, err)
+	}
+}
+
+func mapfileLayers(report *Report) string {
+	var tableNames []string
+	for tableName, tableReport := range report.Tables {
+		if tableReport.GeomColumn != "" {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+	if len(tableNames) == 0 {
+		log.Printf("WARNING: no OWS optimized feature tables in report, mapfile contains no layers")
+	}
+
+	var mapfile strings.Builder
+	line := func(indent int, format string, args ...any) {
+		mapfile.WriteString(strings.Repeat("  ", indent))
+		mapfile.WriteString(fmt.Sprintf(format, args...))
+		mapfile.WriteString("\n")
+	}
+	line(0, "# MapServer layers generated by geopackage-optimizer-go for %s", filepath.Base(report.Geopackage))
+	for _, tableName := range tableNames {
+		tableReport := report.Tables[tableName]
+		layerType, ok := mapServerLayerTypes[strings.ToUpper(tableReport.GeometryType)]
+		if !ok {
+			log.Printf("WARNING: geometry type '%s' of table '%s' has no MapServer layer type, using POLYGON",
+				tableReport.GeometryType, tableName)
+			layerType = "POLYGON"
+		}
+		extent := ""
+		if len(tableReport.Extent) == 4 {
+			var coordinates []string
+			for _, coordinate := range tableReport.Extent {
+				coordinates = append(coordinates, strconv.FormatFloat(coordinate, 'f', -1, 64))
+			}
+			extent = strings.Join(coordinates, " ")
+		}
+
+		line(0, "")
+		line(0, "LAYER")
+		line(1, "NAME %s", strconv.Quote(tableName))
+		line(1, "TYPE %s", layerType)
+		line(1, "STATUS ON")
+		line(1, "CONNECTIONTYPE OGR")
+		line(1, "CONNECTION %s", strconv.Quote(filepath.Base(report.Geopackage)))
+		line(1, "DATA %s", strconv.Quote(tableName))
+		if extent != "" {
+			line(1, "EXTENT %s", extent)
+		}
+		if tableReport.CRS != "" {
+			line(1, "PROJECTION")
+			line(2, "%s", strconv.Quote("init="+strings.ToLower(tableReport.CRS)))
+			line(1, "END")
+		}
+		line(1, "METADATA")
+		metadata := [][2]string{
+			{"wms_title", tableName},
+			{"wfs_title", tableName},
+		}
+		if tableReport.CRS != "" {
+			metadata = append(metadata, [2]string{"wms_srs", tableReport.CRS}, [2]string{"wfs_srs", tableReport.CRS})
+		}
+		if extent != "" {
+			metadata = append(metadata, [2]string{"wms_extent", extent}, [2]string{"wfs_extent", extent})
+		}
+		metadata = append(metadata,
+			[2]string{"gml_featureid", owsFeatureID},
+			[2]string{"gml_include_items", "all"},
+			[2]string{"gml_geometries", tableReport.GeomColumn},
+			[2]string{"wfs_enable_request", "*"},
+			[2]string{"wms_enable_request", "*"},
+		)
+		for _, item := range metadata {
+			line(2, "%s %s", strconv.Quote(item[0]), strconv.Quote(item[1]))
+		}
+		line(1, "END")
+		for _, index := range tableReport.Indexes {
+			line(1, "# indexed: %s (%s)", index.Name, strings.Join(index.Columns, ", "))
+		}
+		line(0, "END")
+	}
+	return mapfile.String()
+}
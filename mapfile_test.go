@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestMapfileLayers(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL, "+
+		"organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)", db)
+	executeQuery("INSERT INTO gpkg_spatial_ref_sys VALUES ('Amersfoort / RD New', 28992, 'EPSG', 28992, 'undefined', NULL)", db)
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT PRIMARY KEY, min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('layer', 10000, 300000, 280000, 625000.5)", db)
+
+	report := newReport("testdata/original_ows.gpkg", "ows")
+	table := Table{Name: "layer", DataType: "features", IsFeatures: true,
+		GeometryColumns: []GeometryColumn{{Name: "geom", GeometryType: "POINT", SrsID: epsgRDNew}}}
+	reportOWSLayer(table, report, db)
+	reportOWSLayer(Table{Name: "layer_styles", DataType: "attributes"}, report, db)
+	report.table("layer").Indexes = []IndexInspection{{Name: "layer_name_idx", Columns: []string{"name"}}}
+
+	mapfile := mapfileLayers(report)
+	for _, expected := range []string{
+		"LAYER\n  NAME \"layer\"\n  TYPE POINT\n  STATUS ON\n  CONNECTIONTYPE OGR\n  CONNECTION \"original_ows.gpkg\"\n  DATA \"layer\"\n",
+		"  EXTENT 10000 300000 280000 625000.5\n  PROJECTION\n    \"init=epsg:28992\"\n  END\n",
+		"    \"wms_srs\" \"EPSG:28992\"\n",
+		"    \"gml_featureid\" \"fuuid\"\n",
+		"  # indexed: layer_name_idx (name)\nEND\n",
+	} {
+		if !strings.Contains(mapfile, expected) {
+			log.Fatalf("expected mapfile to contain\n%s\ngot\n%s", expected, mapfile)
+		}
+	}
+	if strings.Contains(mapfile, "layer_styles") {
+		log.Fatalf("expected only feature tables in mapfile, got\n%s", mapfile)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// schema name of the attached input GeoPackage while merging
+const mergeInput = "merge_input"
+
+// mergeGeopackages merges the given GeoPackages, which have identical schemas (e.g. a GeoPackage per municipality),
+// into a new GeoPackage. The merged GeoPackage starts as a copy of the first input, so its gpkg_* metadata is used.
+// The rows of every table of the other inputs are appended to the same table, geometries in another CRS are
+// transformed and rows with a fid that is already taken get a new fid. Finally, the extents in gpkg_contents and
+// feature counts in gpkg_ogr_contents are recomputed.
+func mergeGeopackages(inputs []string, mergedGeopackage string) *MergeReport {
+	if len(inputs) < 2 {
+		log.Fatalf("at least two geopackages are required to merge, got %d", len(inputs))
+	}
+	if _, err := os.Stat(mergedGeopackage); err == nil {
+		log.Fatalf("merged geopackage '%s' already exists", mergedGeopackage)
+	}
+	log.Printf("Merging %d geopackages into '%s'...\n", len(inputs), mergedGeopackage)
+
+	first := openDb(inputs[0])
+	executeQuery(fmt.Sprintf("VACUUM INTO '%s'", sqlString(mergedGeopackage)), first)
+	first.Close()
+
+	db := openDb(mergedGeopackage)
+	defer db.Close()
+	// an attached database is only available on the connection that attached it
+	db.SetMaxOpenConns(1)
+
+	tables := readTables(db)
+	report := &MergeReport{Tables: make(map[string]*MergeTableReport)}
+	for _, table := range tables {
+		report.Tables[table.Name] = &MergeTableReport{}
+		err := db.QueryRow(fmt.Sprintf("select count(*) from \"%s\"", table.Name)).Scan(&report.Tables[table.Name].Rows)
+		if err != nil {
+			log.Fatalf("error counting rows of table '%s': %s", table.Name, err)
+		}
+	}
+	for _, input := range inputs {
+		report.Inputs = append(report.Inputs, filepath.Base(input))
+	}
+
+	for _, input := range inputs[1:] {
+		log.Printf("Merging '%s'", input)
+		_, err := db.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", mergeInput), input)
+		if err != nil {
+			log.Fatalf("error attaching '%s': %s", input, err)
+		}
+		for _, tableName := range queryStrings(fmt.Sprintf("select table_name from %s.gpkg_contents", mergeInput), db) {
+			table, ok := findTable(tables, tableName)
+			if !ok {
+				log.Fatalf("table '%s' of '%s' is missing in '%s', the schemas of the geopackages must be identical",
+					tableName, input, inputs[0])
+			}
+			mergeTable(table, filepath.Base(input), report.Tables[tableName], db)
+		}
+		executeQuery("DETACH DATABASE "+mergeInput, db)
+	}
+
+	for _, table := range tables {
+		recomputeContents(table, db)
+	}
+	return report
+}
+
+// mergeTable appends the rows of the given table of the attached input to the same table of the merged GeoPackage.
+// Rows keep their fid unless it's already taken, in which case a new fid is assigned. Geometries in another CRS than the
+// merged geometry column are transformed.
+func mergeTable(table Table, input string, tableReport *MergeTableReport, db *sql.DB) {
+	if !table.IsFeatures && table.DataType != "attributes" {
+		log.Printf("WARNING: table '%s' of type '%s' is not merged, only the first geopackage is used", table.Name, table.DataType)
+		return
+	}
+	mergedColumns := readColumns(table.Name, db)
+	inputColumns := readSchemaColumns(table.Name, mergeInput, db)
+	if !sameColumns(mergedColumns, inputColumns) {
+		log.Fatalf("columns of table '%s' of '%s' differ: %v instead of %v, the schemas of the geopackages must be identical",
+			table.Name, input, inputColumns, mergedColumns)
+	}
+	var columns []string
+	for _, column := range mergedColumns {
+		columns = append(columns, column.Name)
+	}
+
+	selection := make(map[string]string)
+	for _, column := range columns {
+		selection[column] = fmt.Sprintf("\"%s\"", column)
+	}
+	transformed := false
+	for _, geomColumn := range table.GeometryColumns {
+		var geometryType string
+		var srsID int64
+		err := db.QueryRow(fmt.Sprintf("select geometry_type_name, srs_id from %s.gpkg_geometry_columns where table_name = ? and column_name = ?", mergeInput),
+			table.Name, geomColumn.Name).Scan(&geometryType, &srsID)
+		if err != nil {
+			log.Fatalf("error reading geometry column '%s' of table '%s' of '%s': %s", geomColumn.Name, table.Name, input, err)
+		}
+		if !strings.EqualFold(geometryType, geomColumn.GeometryType) {
+			log.Fatalf("geometry column '%s' of table '%s' of '%s' has geometry type %s instead of %s, the schemas of the geopackages must be identical",
+				geomColumn.Name, table.Name, input, geometryType, geomColumn.GeometryType)
+		}
+		if srsID != geomColumn.SrsID {
+			log.Printf("Transforming geometry column '%s' of table '%s' of '%s' from EPSG:%d to EPSG:%d",
+				geomColumn.Name, table.Name, input, srsID, geomColumn.SrsID)
+			selection[geomColumn.Name] = fmt.Sprintf("AsGPB(ST_Transform(GeomFromGPB(\"%s\"), %d))", geomColumn.Name, geomColumn.SrsID)
+			transformed = true
+		}
+	}
+	if transformed {
+		tableReport.TransformedInputs = append(tableReport.TransformedInputs, input)
+	}
+
+	// the taken fids are determined up front, since both inserts add fids
+	fidColumn := detectFidColumn(table.Name, db)
+	executeQuery(fmt.Sprintf("CREATE TEMP TABLE merge_taken_fids AS SELECT \"%[1]s\" AS fid FROM %[2]s.\"%[3]s\" "+
+		"WHERE \"%[1]s\" IN (SELECT \"%[1]s\" FROM main.\"%[3]s\")", fidColumn, mergeInput, table.Name), db)
+
+	var keptColumns, keptSelection, reassignedColumns, reassignedSelection []string
+	for _, column := range columns {
+		keptColumns = append(keptColumns, fmt.Sprintf("\"%s\"", column))
+		keptSelection = append(keptSelection, selection[column])
+		if column != fidColumn {
+			reassignedColumns = append(reassignedColumns, fmt.Sprintf("\"%s\"", column))
+			reassignedSelection = append(reassignedSelection, selection[column])
+		}
+	}
+	kept := insertRows(fmt.Sprintf("INSERT INTO main.\"%s\" (%s) SELECT %s FROM %s.\"%s\" WHERE \"%s\" NOT IN (SELECT fid FROM temp.merge_taken_fids)",
+		table.Name, strings.Join(keptColumns, ", "), strings.Join(keptSelection, ", "), mergeInput, table.Name, fidColumn), db)
+	reassigned := insertRows(fmt.Sprintf("INSERT INTO main.\"%s\" (%s) SELECT %s FROM %s.\"%s\" WHERE \"%s\" IN (SELECT fid FROM temp.merge_taken_fids) ORDER BY \"%[6]s\"",
+		table.Name, strings.Join(reassignedColumns, ", "), strings.Join(reassignedSelection, ", "), mergeInput, table.Name, fidColumn), db)
+	executeQuery("DROP TABLE temp.merge_taken_fids", db)
+
+	if reassigned > 0 {
+		log.Printf("WARNING: %d rows of table '%s' of '%s' got a new fid, since their fid was already taken", reassigned, table.Name, input)
+	}
+	tableReport.Rows += kept + reassigned
+	tableReport.ReassignedFids += reassigned
+}
+
+// sameColumns returns whether the given columns have the same names, types and primary key, regardless of their order
+func sameColumns(columns []Column, other []Column) bool {
+	describe := func(columns []Column) string {
+		var result []string
+		for _, column := range columns {
+			result = append(result, fmt.Sprintf("%s %s %t", column.Name, strings.ToUpper(column.Type), column.PrimaryKey))
+		}
+		sort.Strings(result)
+		return strings.Join(result, ",")
+	}
+	return describe(columns) == describe(other)
+}
+
+// insertRows executes the given insert statement and returns the number of inserted rows
+func insertRows(query string, db *sql.DB) int64 {
+	log.Printf("executing query: %s;\n", query)
+	result, err := db.Exec(query)
+	if err != nil {
+		log.Fatalf("error executing query: '%s'", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rows
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestMergeTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE eigenaar (fid INTEGER PRIMARY KEY, naam TEXT)", db)
+	executeQuery("INSERT INTO eigenaar VALUES (1, 'Jansen'), (2, 'De Vries')", db)
+	executeQuery("ATTACH DATABASE ':memory:' AS "+mergeInput, db)
+	// same columns in another order
+	executeQuery("CREATE TABLE merge_input.eigenaar (naam TEXT, fid INTEGER PRIMARY KEY)", db)
+	executeQuery("INSERT INTO merge_input.eigenaar VALUES ('Bakker', 2), ('Visser', 3), ('Smit', 1)", db)
+
+	tableReport := &MergeTableReport{Rows: 2}
+	mergeTable(Table{Name: "eigenaar", DataType: "attributes"}, "noord.gpkg", tableReport, db)
+
+	if tableReport.Rows != 5 || tableReport.ReassignedFids != 2 || len(tableReport.TransformedInputs) != 0 {
+		log.Fatalf("expected 5 rows of which 2 with a new fid, got %+v", tableReport)
+	}
+	expected := []string{"1 Jansen", "2 De Vries", "3 Visser", "4 Smit", "5 Bakker"}
+	merged := queryStrings("select fid || ' ' || naam from main.eigenaar order by fid", db)
+	if len(merged) != len(expected) {
+		log.Fatalf("expected rows %v, got %v", expected, merged)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			log.Fatalf("expected rows %v, got %v", expected, merged)
+		}
+	}
+	if temp := queryStrings("select name from temp.sqlite_master", db); len(temp) != 0 {
+		log.Fatalf("expected the taken fids to be dropped, got %v", temp)
+	}
+}
+
+func TestSameColumns(t *testing.T) {
+	columns := []Column{{Name: "fid", Type: "INTEGER", PrimaryKey: true}, {Name: "naam", Type: "TEXT"}}
+	if !sameColumns(columns, []Column{{Name: "naam", Type: "text"}, {Name: "fid", Type: "INTEGER", PrimaryKey: true}}) {
+		log.Fatal("expected the same columns in another order to be the same")
+	}
+	if sameColumns(columns, []Column{{Name: "fid", Type: "INTEGER", PrimaryKey: true}, {Name: "naam", Type: "INTEGER"}}) {
+		log.Fatal("expected a column with another type to differ")
+	}
+	if sameColumns(columns, []Column{{Name: "fid", Type: "INTEGER"}, {Name: "naam", Type: "TEXT"}}) {
+		log.Fatal("expected a column with another primary key to differ")
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Mapbox Vector Tile encoding, see https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+const (
+	mvtVersion = 2
+
+	mvtPoint      = 1
+	mvtLineString = 2
+	mvtPolygon    = 3
+
+	mvtMoveTo    = 1
+	mvtLineTo    = 2
+	mvtClosePath = 7
+
+	// protobuf wire types
+	wireVarint = 0
+	wire64Bit  = 1
+	wireBytes  = 2
+)
+
+// MVTLayer collects the features of a layer of a vector tile
+type MVTLayer struct {
+	Name   string
+	Extent uint32
+	// Buffer around the tile in tile coordinates, geometries are clipped to the buffered tile
+	Buffer   uint32
+	features [][]byte
+	keys     []string
+	keyIndex map[string]uint32
+	values   [][]byte
+	valIndex map[string]uint32
+}
+
+func newMVTLayer(name string, extent uint32, buffer uint32) *MVTLayer {
+	return &MVTLayer{
+		Name:     name,
+		Extent:   extent,
+		Buffer:   buffer,
+		keyIndex: make(map[string]uint32),
+		valIndex: make(map[string]uint32),
+	}
+}
+
+// tileTransform maps coordinates (in the CRS of the tile matrix set) to tile coordinates
+type tileTransform struct {
+	minX, maxY, span float64
+	extent           uint32
+}
+
+func (t tileTransform) apply(point [2]float64) [2]float64 {
+	return [2]float64{
+		(point[0] - t.minX) / t.span * float64(t.extent),
+		(t.maxY - point[1]) / t.span * float64(t.extent),
+	}
+}
+
+// addFeature adds a feature with the given geometry (in tile coordinates, not yet clipped) and attributes,
+// returns false if nothing of the geometry remains after clipping
+func (l *MVTLayer) addFeature(id *uint64, geometry Geometry, attributes map[string]any) bool {
+	tags := l.tags(attributes)
+	added := false
+	minimum, maximum := -float64(l.Buffer), float64(l.Extent+l.Buffer)
+
+	var points [][2]int64
+	for _, point := range geometry.Points {
+		if point[0] >= minimum && point[0] <= maximum && point[1] >= minimum && point[1] <= maximum {
+			points = append(points, roundPoint(point))
+		}
+	}
+	if len(points) > 0 {
+		l.features = append(l.features, encodeFeature(id, tags, mvtPoint, encodePoints(points)))
+		added = true
+	}
+
+	var lines [][][2]int64
+	for _, line := range geometry.Lines {
+		for _, part := range clipLine(line, minimum, maximum) {
+			if quantized := quantize(part); len(quantized) >= 2 {
+				lines = append(lines, quantized)
+			}
+		}
+	}
+	if len(lines) > 0 {
+		l.features = append(l.features, encodeFeature(id, tags, mvtLineString, encodeLines(lines)))
+		added = true
+	}
+
+	var rings [][][2]int64
+	for _, polygon := range geometry.Polygons {
+		for i, ring := range polygon {
+			quantized := quantize(clipRing(ring, minimum, maximum))
+			if len(quantized) > 1 && quantized[0] == quantized[len(quantized)-1] {
+				quantized = quantized[:len(quantized)-1]
+			}
+			area := ringArea(quantized)
+			if len(quantized) < 3 || area == 0 {
+				if i == 0 {
+					// without exterior ring, the holes are irrelevant
+					break
+				}
+				continue
+			}
+			// exterior rings have a positive area (clockwise in screen coordinates), interior rings a negative area
+			if (i == 0) != (area > 0) {
+				for a, b := 0, len(quantized)-1; a < b; a, b = a+1, b-1 {
+					quantized[a], quantized[b] = quantized[b], quantized[a]
+				}
+			}
+			rings = append(rings, quantized)
+		}
+	}
+	if len(rings) > 0 {
+		l.features = append(l.features, encodeFeature(id, tags, mvtPolygon, encodeRings(rings)))
+		added = true
+	}
+	return added
+}
+
+// tags returns the key and value indexes of the given attributes, in order of key
+func (l *MVTLayer) tags(attributes map[string]any) []uint32 {
+	var keys []string
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tags []uint32
+	for _, key := range keys {
+		value := encodeValue(attributes[key])
+		if value == nil {
+			continue
+		}
+		keyIndex, ok := l.keyIndex[key]
+		if !ok {
+			keyIndex = uint32(len(l.keys))
+			l.keyIndex[key] = keyIndex
+			l.keys = append(l.keys, key)
+		}
+		valueIndex, ok := l.valIndex[string(value)]
+		if !ok {
+			valueIndex = uint32(len(l.values))
+			l.valIndex[string(value)] = valueIndex
+			l.values = append(l.values, value)
+		}
+		tags = append(tags, keyIndex, valueIndex)
+	}
+	return tags
+}
+
+func (l *MVTLayer) empty() bool {
+	return len(l.features) == 0
+}
+
+// encode returns the protobuf encoding of the layer
+func (l *MVTLayer) encode() []byte {
+	var layer []byte
+	layer = appendVarintField(layer, 15, mvtVersion)
+	layer = appendBytesField(layer, 1, []byte(l.Name))
+	for _, feature := range l.features {
+		layer = appendBytesField(layer, 2, feature)
+	}
+	for _, key := range l.keys {
+		layer = appendBytesField(layer, 3, []byte(key))
+	}
+	for _, value := range l.values {
+		layer = appendBytesField(layer, 4, value)
+	}
+	layer = appendVarintField(layer, 5, uint64(l.Extent))
+	return layer
+}
+
+// encodeTile returns the protobuf encoding of a vector tile with the given (non-empty) layers
+func encodeTile(layers []*MVTLayer) []byte {
+	var tile []byte
+	for _, layer := range layers {
+		if !layer.empty() {
+			tile = appendBytesField(tile, 3, layer.encode())
+		}
+	}
+	return tile
+}
+
+func encodeFeature(id *uint64, tags []uint32, geometryType uint64, geometry []uint32) []byte {
+	var feature []byte
+	if id != nil {
+		feature = appendVarintField(feature, 1, *id)
+	}
+	if len(tags) > 0 {
+		feature = appendPackedField(feature, 2, tags)
+	}
+	feature = appendVarintField(feature, 3, geometryType)
+	return appendPackedField(feature, 4, geometry)
+}
+
+// encodeValue returns the protobuf encoding of an attribute value, or nil for unsupported values (e.g. NULL)
+func encodeValue(value any) []byte {
+	switch v := value.(type) {
+	case string:
+		return appendBytesField(nil, 1, []byte(v))
+	case []byte:
+		// text columns may be scanned as bytes
+		return appendBytesField(nil, 1, v)
+	case float64:
+		return appendFixed64Field(nil, 3, math.Float64bits(v))
+	case int64:
+		if v < 0 {
+			return appendVarintField(nil, 6, zigzag(v))
+		}
+		return appendVarintField(nil, 4, uint64(v))
+	case bool:
+		var b uint64
+		if v {
+			b = 1
+		}
+		return appendVarintField(nil, 7, b)
+	default:
+		return nil
+	}
+}
+
+func encodePoints(points [][2]int64) []uint32 {
+	var cursor [2]int64
+	geometry := []uint32{mvtCommand(mvtMoveTo, len(points))}
+	for _, point := range points {
+		geometry = append(geometry, uint32(zigzag(point[0]-cursor[0])), uint32(zigzag(point[1]-cursor[1])))
+		cursor = point
+	}
+	return geometry
+}
+
+func encodeLines(lines [][][2]int64) []uint32 {
+	var cursor [2]int64
+	var geometry []uint32
+	for _, line := range lines {
+		geometry, cursor = appendPath(geometry, cursor, line)
+	}
+	return geometry
+}
+
+func encodeRings(rings [][][2]int64) []uint32 {
+	var cursor [2]int64
+	var geometry []uint32
+	for _, ring := range rings {
+		geometry, cursor = appendPath(geometry, cursor, ring)
+		geometry = append(geometry, mvtCommand(mvtClosePath, 1))
+	}
+	return geometry
+}
+
+func appendPath(geometry []uint32, cursor [2]int64, path [][2]int64) ([]uint32, [2]int64) {
+	for i, point := range path {
+		switch i {
+		case 0:
+			geometry = append(geometry, mvtCommand(mvtMoveTo, 1))
+		case 1:
+			geometry = append(geometry, mvtCommand(mvtLineTo, len(path)-1))
+		}
+		geometry = append(geometry, uint32(zigzag(point[0]-cursor[0])), uint32(zigzag(point[1]-cursor[1])))
+		cursor = point
+	}
+	return geometry, cursor
+}
+
+func mvtCommand(id int, count int) uint32 {
+	return uint32(id&0x7) | uint32(count)<<3
+}
+
+func zigzag(value int64) uint64 {
+	return uint64((value << 1) ^ (value >> 63))
+}
+
+func roundPoint(point [2]float64) [2]int64 {
+	return [2]int64{int64(math.Round(point[0])), int64(math.Round(point[1]))}
+}
+
+// quantize rounds the given points to integer tile coordinates, dropping repeated points
+func quantize(points [][2]float64) [][2]int64 {
+	var result [][2]int64
+	for _, point := range points {
+		rounded := roundPoint(point)
+		if len(result) == 0 || result[len(result)-1] != rounded {
+			result = append(result, rounded)
+		}
+	}
+	return result
+}
+
+// ringArea returns the signed area (surveyor's formula) of the given ring, positive when clockwise in screen coordinates
+func ringArea(ring [][2]int64) int64 {
+	var area int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return area
+}
+
+// clipRing clips a ring to the square [minimum, maximum] (Sutherland-Hodgman), the result may contain degenerate edges
+// along the border of the square, which is fine for rendering
+func clipRing(ring [][2]float64, minimum float64, maximum float64) [][2]float64 {
+	type edge struct {
+		inside    func(p [2]float64) bool
+		intersect func(a, b [2]float64) [2]float64
+	}
+	atX := func(a, b [2]float64, x float64) [2]float64 {
+		return [2]float64{x, a[1] + (b[1]-a[1])*(x-a[0])/(b[0]-a[0])}
+	}
+	atY := func(a, b [2]float64, y float64) [2]float64 {
+		return [2]float64{a[0] + (b[0]-a[0])*(y-a[1])/(b[1]-a[1]), y}
+	}
+	edges := []edge{
+		{func(p [2]float64) bool { return p[0] >= minimum }, func(a, b [2]float64) [2]float64 { return atX(a, b, minimum) }},
+		{func(p [2]float64) bool { return p[0] <= maximum }, func(a, b [2]float64) [2]float64 { return atX(a, b, maximum) }},
+		{func(p [2]float64) bool { return p[1] >= minimum }, func(a, b [2]float64) [2]float64 { return atY(a, b, minimum) }},
+		{func(p [2]float64) bool { return p[1] <= maximum }, func(a, b [2]float64) [2]float64 { return atY(a, b, maximum) }},
+	}
+
+	result := ring
+	for _, e := range edges {
+		input := result
+		result = nil
+		for i, current := range input {
+			previous := input[(i+len(input)-1)%len(input)]
+			switch {
+			case e.inside(current) && !e.inside(previous):
+				result = append(result, e.intersect(previous, current), current)
+			case e.inside(current):
+				result = append(result, current)
+			case e.inside(previous):
+				result = append(result, e.intersect(previous, current))
+			}
+		}
+	}
+	return result
+}
+
+// clipLine clips a line to the square [minimum, maximum] (Liang-Barsky per segment), returns the parts inside
+func clipLine(line [][2]float64, minimum float64, maximum float64) [][][2]float64 {
+	var parts [][][2]float64
+	var current [][2]float64
+	for i := 1; i < len(line); i++ {
+		a, b, ok := clipSegment(line[i-1], line[i], minimum, maximum)
+		if !ok {
+			if len(current) > 0 {
+				parts = append(parts, current)
+				current = nil
+			}
+			continue
+		}
+		if len(current) == 0 || current[len(current)-1] != a {
+			if len(current) > 0 {
+				parts = append(parts, current)
+			}
+			current = [][2]float64{a}
+		}
+		current = append(current, b)
+	}
+	if len(current) > 0 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+func clipSegment(a, b [2]float64, minimum float64, maximum float64) ([2]float64, [2]float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, boundary := range [][2]float64{{-dx, a[0] - minimum}, {dx, maximum - a[0]}, {-dy, a[1] - minimum}, {dy, maximum - a[1]}} {
+		p, q := boundary[0], boundary[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return a, b, false
+		}
+	}
+	return [2]float64{a[0] + t0*dx, a[1] + t0*dy}, [2]float64{a[0] + t1*dx, a[1] + t1*dy}, true
+}
+
+func appendVarint(b []byte, value uint64) []byte {
+	for value >= 0x80 {
+		b = append(b, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(b, byte(value))
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|wireVarint))
+	return appendVarint(b, value)
+}
+
+func appendFixed64Field(b []byte, field int, value uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|wire64Bit))
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(value>>(8*i)))
+	}
+	return b
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendVarint(b, uint64(field<<3|wireBytes))
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendPackedField(b []byte, field int, values []uint32) []byte {
+	var packed []byte
+	for _, value := range values {
+		packed = appendVarint(packed, uint64(value))
+	}
+	return appendBytesField(b, field, packed)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeGeometry(t *testing.T) {
+	// examples of the vector tile specification
+	if geometry := encodePoints([][2]int64{{25, 17}}); !reflect.DeepEqual(geometry, []uint32{9, 50, 34}) {
+		log.Fatalf("unexpected point encoding %v", geometry)
+	}
+	if geometry := encodeLines([][][2]int64{{{2, 2}, {2, 10}, {10, 10}}}); !reflect.DeepEqual(geometry, []uint32{9, 4, 4, 18, 0, 16, 16, 0}) {
+		log.Fatalf("unexpected linestring encoding %v", geometry)
+	}
+	if geometry := encodeRings([][][2]int64{{{3, 6}, {8, 12}, {20, 34}}}); !reflect.DeepEqual(geometry, []uint32{9, 6, 12, 18, 10, 12, 24, 44, 15}) {
+		log.Fatalf("unexpected polygon encoding %v", geometry)
+	}
+}
+
+func TestAddFeature(t *testing.T) {
+	layer := newMVTLayer("buildings", 4096, 64)
+	id := uint64(1)
+	// counter-clockwise exterior ring (in screen coordinates), partly outside the buffered tile
+	square := [][2]float64{{-1000, -1000}, {-1000, 100}, {100, 100}, {100, -1000}, {-1000, -1000}}
+	if !layer.addFeature(&id, Geometry{Polygons: [][][][2]float64{{square}}}, map[string]any{"year": int64(1920), "name": "a"}) {
+		log.Fatal("expected polygon to be added")
+	}
+	if layer.addFeature(&id, Geometry{Points: [][2]float64{{-100, 5000}}}, nil) {
+		log.Fatal("expected point outside buffered tile to be skipped")
+	}
+
+	if len(layer.features) != 1 || !reflect.DeepEqual(layer.keys, []string{"name", "year"}) || len(layer.values) != 2 {
+		log.Fatalf("unexpected layer %+v", layer)
+	}
+	// polygon clipped to the buffer (-64), with a clockwise exterior ring
+	expected := encodeFeature(&id, []uint32{0, 0, 1, 1}, mvtPolygon, encodeRings([][][2]int64{{{100, -64}, {100, 100}, {-64, 100}, {-64, -64}}}))
+	if !bytes.Equal(layer.features[0], expected) {
+		log.Fatalf("expected feature %v, got %v", expected, layer.features[0])
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	// leaves the square at (5, 10) and enters again at (7, 10)
+	parts := clipLine([][2]float64{{-10, 5}, {5, 5}, {5, 20}, {8, 5}, {20, 5}}, 0, 10)
+	expected := [][][2]float64{{{0, 5}, {5, 5}, {5, 10}}, {{7, 10}, {8, 5}, {10, 5}}}
+	if !reflect.DeepEqual(parts, expected) {
+		log.Fatalf("expected parts %v, got %v", expected, parts)
+	}
+}
@@ -1,11 +1,30 @@
 package main
 
-import "log"
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/creasty/defaults"
+)
 
 type OafConfig struct {
 	Layers map[string]Layer `json:"layers"`
 }
 
+// parseOafConfig unmarshals the given JSON config and applies the defaults
+func parseOafConfig(config string) OafConfig {
+	var oafConfig OafConfig
+	err := json.Unmarshal([]byte(config), &oafConfig)
+	if err != nil {
+		log.Fatalf("cannot unmarshal oaf config: %s", err)
+	}
+	err = defaults.Set(&oafConfig)
+	if err != nil {
+		log.Fatalf("failed to set default config: %s", err)
+	}
+	return oafConfig
+}
+
 func (o OafConfig) getLayer(tableName string) (Layer, bool) {
 	if _, ok := o.Layers[tableName]; !ok {
 		log.Printf("WARNING: no config found for gpkg table '%s'", tableName)
@@ -15,12 +34,115 @@ func (o OafConfig) getLayer(tableName string) (Layer, bool) {
 }
 
 type Layer struct {
-	FidColumn          string     `json:"fid-column" default:"fid"`
-	GeomColumn         string     `json:"geom-column" default:"geom"`
-	SQLStatements      []string   `json:"sql-statements"`
-	ExternalFidColumns []string   `json:"external-fid-columns"`
-	TemporalColumns    []string   `json:"temporal-columns"`
-	Relations          []Relation `json:"relations"`
+	FidColumn          string           `json:"fid-column"`
+	GeomColumn         string           `json:"geom-column"`
+	GeomColumns        []string         `json:"geom-columns"`
+	Validation         *Validation      `json:"validation"`
+	SQLStatements      []string         `json:"sql-statements"`
+	ExternalFidColumns []string         `json:"external-fid-columns"`
+	TemporalColumns    []string         `json:"temporal-columns"`
+	BBoxCRS            []int64          `json:"bbox-crs"`
+	AdditionalCRS      []int64          `json:"additional-crs"`
+	Generalizations    []Generalization `json:"generalization"`
+	LabelPoint         *LabelPoint      `json:"label-point"`
+	Queryables         []Queryable      `json:"queryables"`
+	Search             *Search          `json:"search"`
+	Relations          []Relation       `json:"relations"`
+	// CurveKey is the space-filling curve (hilbert or morton) of the curve key column, by default none is added
+	CurveKey string `json:"curve-key"`
+}
+
+// geometryColumns resolves the geometry columns to optimize for the given table. Configured geometry columns
+// take precedence, otherwise the geometry columns registered in gpkg_geometry_columns are used.
+// The first geometry column is the primary geometry column of the table.
+func (l Layer) geometryColumns(table Table) []string {
+	var result []string
+	if l.GeomColumn != "" {
+		result = append(result, l.GeomColumn)
+	}
+	for _, geomColumn := range l.GeomColumns {
+		if geomColumn != l.GeomColumn {
+			result = append(result, geomColumn)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	for _, geomColumn := range table.GeometryColumns {
+		result = append(result, geomColumn.Name)
+	}
+	if len(result) == 0 && table.IsFeatures {
+		log.Printf("WARNING: no geometry column registered for table '%s', assuming 'geom'", table.Name)
+		result = append(result, "geom")
+	}
+	return result
+}
+
+// spatialOptimizations returns the default and configured optimizations that only apply to feature tables, so they
+// are omitted for attribute (non-spatial) tables
+func (l Layer) spatialOptimizations() []string {
+	result := []string{"bbox", "spatial-index"}
+	if l.Validation != nil {
+		result = append(result, "validation")
+	}
+	if l.BBoxCRS != nil {
+		result = append(result, "bbox-crs")
+	}
+	if l.AdditionalCRS != nil {
+		result = append(result, "additional-crs")
+	}
+	if l.Generalizations != nil {
+		result = append(result, "generalization")
+	}
+	if l.LabelPoint != nil {
+		result = append(result, "label-point")
+	}
+	if l.CurveKey != "" {
+		result = append(result, "curve-key")
+	}
+	return result
+}
+
+// Queryable is a property on which features can be filtered (OGC API Features Part 3).
+// By default the column with the same name is indexed, optionally an expression can be indexed instead.
+type Queryable struct {
+	Name            string `json:"name"`
+	Expression      string `json:"expression"`
+	CaseInsensitive bool   `json:"case-insensitive"`
+	Type            string `json:"type"`
+}
+
+// Validation configures validation of geometries, the action for NULL, empty or invalid geometries is
+// either "report" (default), "repair" or "exclude"
+type Validation struct {
+	Action string `json:"action" default:"report"`
+}
+
+// Generalization is a scale level for which a simplified (topology preserving) geometry is computed,
+// the tolerance is in units of the CRS of the geometry
+type Generalization struct {
+	Name      string  `json:"name"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+// LabelPoint configures a representative point that is guaranteed to lie inside the geometry
+type LabelPoint struct {
+	Geometry bool `json:"geometry"`
+}
+
+// Search configures a full-text (FTS5) search index over the given columns
+type Search struct {
+	Columns        []string `json:"columns"`
+	Tokenizer      string   `json:"tokenizer" default:"unicode61"`
+	KeepDiacritics bool     `json:"keep-diacritics"`
+	Prefix         []int    `json:"prefix"`
+}
+
+func (s *Search) tokenizer() string {
+	if s.Tokenizer == "unicode61" && !s.KeepDiacritics {
+		return "unicode61 remove_diacritics 2"
+	}
+	return s.Tokenizer
 }
 
 type Relation struct {
@@ -2,13 +2,10 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
-
-	"github.com/creasty/defaults"
 )
 
 const (
@@ -17,39 +14,92 @@ const (
 
 func main() {
 	log.Println("Starting...")
-	sourceGeopackage := flag.String("s", "empty", "source geopackage")
-	serviceType := flag.String("service-type", "ows", "service type to optimize geopackage for")
-	config := flag.String("config", "", "optional JSON config for additional optimizations")
+	if len(os.Args) > 1 {
+		if cmd, ok := findCommand(os.Args[1]); ok {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	// commandline without subcommand, from before subcommands existed
+	optimize(legacyArgs(os.Args[1:]))
+}
+
+type serviceOptimizer struct {
+	optimize func(config string, report *Report, db *sql.DB)
+	// parseConfig returns the config after defaults are applied, as recorded in the provenance
+	parseConfig func(config string) any
+}
 
-	flag.Parse()
+// optimizers per service type
+var optimizers = map[string]serviceOptimizer{
+	"ows":   {optimizeOWS, func(config string) any { return parseOwsConfig(config) }},
+	"oaf":   {optimizeOAF, func(config string) any { return parseOafConfig(config) }},
+	"tiles": {optimizeTiles, func(config string) any { return parseTilesConfig(config) }},
+}
 
-	switch *serviceType {
-	case "ows":
-		optimizeOWSGeopackage(*sourceGeopackage, *config)
-	case "oaf":
-		optimizeOAFGeopackage(*sourceGeopackage, *config)
-	default:
-		log.Fatalf("invalid value for service-type: '%s'", *serviceType)
-	}
+type optimizeOptions struct {
+	// dryRun performs all optimizations in a transaction that is rolled back afterward
+	dryRun bool
+	// force allows re-optimizing a GeoPackage that was already optimized
+	force bool
+	// gpkgMetadata also adds the provenance to gpkg_metadata
+	gpkgMetadata bool
+	// cluster renumbers the features of every feature table along this space-filling curve (hilbert or morton)
+	cluster string
 }
 
-func optimizeOAFGeopackage(sourceGeopackage string, config string) {
-	log.Printf("Performing OAF optimizations for geopackage: '%s'...\n", sourceGeopackage)
+// optimizeGeopackage performs the optimizations for the given service type. With dryRun all optimizations are
+// performed in a transaction that is rolled back afterward, so the GeoPackage is left untouched.
+func optimizeGeopackage(sourceGeopackage string, serviceType string, config string, options optimizeOptions) *Report {
+	optimizer, ok := optimizers[serviceType]
+	if !ok {
+		log.Fatalf("invalid value for service-type: '%s'", serviceType)
+	}
+	effectiveConfig := ""
+	if config != "" {
+		effectiveConfig = marshalConfig(optimizer.parseConfig(config))
+	}
+	provenance := newProvenance(serviceType, effectiveConfig, fileChecksum(sourceGeopackage))
+
+	log.Printf("Performing %s optimizations for geopackage: '%s'...\n", strings.ToUpper(serviceType), sourceGeopackage)
 	db := openDb(sourceGeopackage)
 	defer db.Close()
 
+	if options.dryRun {
+		// a single connection, so every statement is part of the transaction
+		db.SetMaxOpenConns(1)
+		executeQuery("BEGIN", db)
+		defer executeQuery("ROLLBACK", db)
+	}
+	if err := checkReoptimization(serviceType, effectiveConfig, options.force, db); err != nil {
+		log.Fatal(err)
+	}
+	lastArtifactID := readLastArtifactID(db)
+
+	report := newReport(sourceGeopackage, serviceType)
+	optimizer.optimize(config, report, db)
+	if options.cluster != "" {
+		clusterFeatureTables(options.cluster, report, db)
+	}
+
+	writeProvenance(provenance, lastArtifactID, options.gpkgMetadata, db)
+	return report
+}
+
+func optimizeOAFGeopackage(sourceGeopackage string, config string) *Report {
+	return optimizeGeopackage(sourceGeopackage, "oaf", config, optimizeOptions{})
+}
+
+func optimizeOWSGeopackage(sourceGeopackage string, config string) *Report {
+	return optimizeGeopackage(sourceGeopackage, "ows", config, optimizeOptions{})
+}
+
+func optimizeOAF(config string, report *Report, db *sql.DB) {
 	tables := readTables(db)
 
 	if config != "" {
-		var oafConfig OafConfig
-		err := json.Unmarshal([]byte(config), &oafConfig)
-		if err != nil {
-			log.Fatalf("cannot unmarshal oaf config: %s", err)
-		}
-		err = defaults.Set(&oafConfig)
-		if err != nil {
-			log.Fatalf("failed to set default config: %s", err)
-		}
+		oafConfig := parseOafConfig(config)
+		resolvedLayers := make(map[string]Layer)
 		for _, table := range tables {
 			layerCfg, ok := oafConfig.getLayer(table.Name)
 			if !ok {
@@ -60,53 +110,154 @@ func optimizeOAFGeopackage(sourceGeopackage string, config string) {
 			for _, stmt := range layerCfg.SQLStatements {
 				executeQuery(stmt, db)
 			}
+			layerCfg = resolveLayer(table, layerCfg, db)
+			resolvedLayers[table.Name] = layerCfg
+			report.table(table.Name).layer(table, layerCfg)
+			if !table.IsFeatures {
+				omitSpatialOptimizations(table, layerCfg, report)
+			}
+
+			// geometries are validated before any bbox is computed
+			if layerCfg.Validation != nil && table.IsFeatures {
+				validateGeometries(table, layerCfg.FidColumn, *layerCfg.Validation, report, db)
+			}
 
 			// add external_fid column, then set it to uuid5 based on concatenation of collection name and content of given columns, and create an index on it
 			if layerCfg.ExternalFidColumns != nil {
 				addColumn(table.Name, "external_fid", "TEXT", db)
 				setColumnValue(table.Name, "external_fid", fmt.Sprintf("uuid5('%s', '%s'||%s)", pdokNamespace, table.Name, strings.Join(layerCfg.ExternalFidColumns, "||")), db)
+				describeColumn(table.Name, "external_fid", "External feature id", fmt.Sprintf("Stable identifier of the feature: "+
+					"UUID version 5 of the table name and %s", strings.Join(layerCfg.ExternalFidColumns, ", ")), uuidConstraint, db)
 				createIndex(table.Name, []string{"external_fid"}, fmt.Sprintf("%s_external_fid_idx", table.Name), false, db)
+				report.table(table.Name).ExternalFid = "external_fid"
 			}
 
 			if layerCfg.TemporalColumns != nil {
 				createIndex(table.Name, layerCfg.TemporalColumns, fmt.Sprintf("%s_temporal_idx", table.Name), false, db)
 			}
 
-			addOAFDefaultOptimizations(table, layerCfg.FidColumn, layerCfg.GeomColumn, layerCfg.TemporalColumns, db)
+			if layerCfg.Queryables != nil {
+				addQueryables(table, layerCfg.Queryables, report, db)
+			}
+
+			if table.IsFeatures {
+				addOAFDefaultOptimizations(table, layerCfg.FidColumn, layerCfg.GeomColumns, layerCfg.TemporalColumns, db)
+
+				if layerCfg.CurveKey != "" {
+					addCurveKey(table, layerCfg.CurveKey, report, db)
+				}
+
+				if layerCfg.BBoxCRS != nil {
+					addBBoxCRSColumns(table, layerCfg, report, db)
+				}
+				if layerCfg.AdditionalCRS != nil {
+					addCRSGeometryColumns(table, layerCfg, report, db)
+				}
+				if layerCfg.Generalizations != nil {
+					addGeneralizedGeometryColumns(table, layerCfg, report, db)
+				}
+				if layerCfg.LabelPoint != nil {
+					addLabelPoint(table, layerCfg.FidColumn, layerCfg.GeomColumn, *layerCfg.LabelPoint, report, db)
+				}
+			}
+		}
+		addRelations(tables, oafConfig, report, db)
+
+		// search indexes are created last, since these include the external_fid and bbox columns as payload, and
+		// their triggers would re-index every feature on bulk updates of these columns
+		for _, table := range tables {
+			if layerCfg, ok := resolvedLayers[table.Name]; ok && layerCfg.Search != nil {
+				addSearchIndex(table, layerCfg, report, db)
+			}
 		}
-		addRelations(tables, oafConfig, db)
 	} else {
 		for _, table := range tables {
-			addOAFDefaultOptimizations(table, "fid", "geom", nil, db)
+			layerCfg := resolveLayer(table, Layer{}, db)
+			report.table(table.Name).layer(table, layerCfg)
+			if !table.IsFeatures {
+				omitSpatialOptimizations(table, layerCfg, report)
+				continue
+			}
+			addOAFDefaultOptimizations(table, layerCfg.FidColumn, layerCfg.GeomColumns, nil, db)
 		}
 	}
 
+	// bring metadata in line with the optimized tables
+	for _, table := range tables {
+		updateExtent(table, report, db)
+		updateFeatureCount(table, report, db)
+	}
+
 	// finally, optimize db by gathering statistics
 	analyze(db)
 }
 
-func addOAFDefaultOptimizations(table Table, fidColumn string, geomColumn string, temporalColumns []string, db *sql.DB) {
+// resolveLayer determines the fid and geometry column(s) of the given table from the GeoPackage metadata,
+// the columns configured in the layer are used as overrides
+func resolveLayer(table Table, layerCfg Layer, db *sql.DB) Layer {
+	if layerCfg.FidColumn == "" {
+		layerCfg.FidColumn = detectFidColumn(table.Name, db)
+	}
+	layerCfg.GeomColumns = layerCfg.geometryColumns(table)
+	if len(layerCfg.GeomColumns) > 0 {
+		layerCfg.GeomColumn = layerCfg.GeomColumns[0]
+		log.Printf("Table '%s' has fid column '%s' and geometry column(s) '%s'",
+			table.Name, layerCfg.FidColumn, strings.Join(layerCfg.GeomColumns, "', '"))
+	} else {
+		log.Printf("Table '%s' has fid column '%s'", table.Name, layerCfg.FidColumn)
+	}
+	return layerCfg
+}
+
+// omitSpatialOptimizations logs and reports the spatial optimizations that are omitted for an attribute (non-spatial)
+// table. The other optimizations (external fid, temporal index, queryables, search and relations) do apply.
+func omitSpatialOptimizations(table Table, layerCfg Layer, report *Report) {
+	omitted := layerCfg.spatialOptimizations()
+	log.Printf("Skipping spatial optimizations (%s) for table '%s' because it is not of type 'features'",
+		strings.Join(omitted, ", "), table.Name)
+	report.table(table.Name).OmittedOptimizations = omitted
+}
+
+func addOAFDefaultOptimizations(table Table, fidColumn string, geomColumns []string, temporalColumns []string, db *sql.DB) {
 	if !table.IsFeatures {
 		log.Printf("Skipping spatial optimizations for table '%s' because it is not of type 'features'", table.Name)
 		return
 	}
-	addColumn(table.Name, "minx", "numeric", db)
-	addColumn(table.Name, "maxx", "numeric", db)
-	addColumn(table.Name, "miny", "numeric", db)
-	addColumn(table.Name, "maxy", "numeric", db)
-	setColumnValue(table.Name, "minx", fmt.Sprintf("ST_MinX(%s)", geomColumn), db)
-	setColumnValue(table.Name, "maxx", fmt.Sprintf("ST_MaxX(%s)", geomColumn), db)
-	setColumnValue(table.Name, "miny", fmt.Sprintf("ST_MinY(%s)", geomColumn), db)
-	setColumnValue(table.Name, "maxy", fmt.Sprintf("ST_MaxY(%s)", geomColumn), db)
+	for i, geomColumn := range geomColumns {
+		// the first geometry column gets the well-known bbox columns and index name,
+		// additional geometry columns get bbox columns and index name prefixed with the geometry column name
+		prefix, indexName := "", fmt.Sprintf("%s_spatial_idx", table.Name)
+		if i > 0 {
+			prefix, indexName = geomColumn+"_", fmt.Sprintf("%s_%s_spatial_idx", table.Name, geomColumn)
+		}
+		addBBoxColumns(table.Name, prefix, geomColumn, db)
+		createSpatialIndex(table.Name, fidColumn, prefix, temporalColumns, indexName, db)
+	}
+}
 
-	spatialColumns := []string{fidColumn, "minx", "maxx", "miny", "maxy"}
+// createSpatialIndex creates the BTree equivalent of an RTree spatial index on the bbox columns with the given prefix
+func createSpatialIndex(tableName string, fidColumn string, prefix string, temporalColumns []string, indexName string, db *sql.DB) {
+	spatialColumns := []string{fidColumn, prefix + "minx", prefix + "maxx", prefix + "miny", prefix + "maxy"}
 	if temporalColumns != nil {
 		spatialColumns = append(spatialColumns, temporalColumns...)
 	}
-	createIndex(table.Name, spatialColumns, fmt.Sprintf("%s_spatial_idx", table.Name), false, db)
+	createIndex(tableName, spatialColumns, indexName, false, db)
 }
 
-func addRelations(tables []Table, oafConfig OafConfig, db *sql.DB) {
+// addBBoxColumns adds minx/maxx/miny/maxy columns (with optional prefix) containing the bbox of the given geometry
+func addBBoxColumns(tableName string, prefix string, geomColumn string, db *sql.DB) {
+	addColumn(tableName, prefix+"minx", "numeric", db)
+	addColumn(tableName, prefix+"maxx", "numeric", db)
+	addColumn(tableName, prefix+"miny", "numeric", db)
+	addColumn(tableName, prefix+"maxy", "numeric", db)
+	setColumnValue(tableName, prefix+"minx", fmt.Sprintf("ST_MinX(%s)", geomColumn), db)
+	setColumnValue(tableName, prefix+"maxx", fmt.Sprintf("ST_MaxX(%s)", geomColumn), db)
+	setColumnValue(tableName, prefix+"miny", fmt.Sprintf("ST_MinY(%s)", geomColumn), db)
+	setColumnValue(tableName, prefix+"maxy", fmt.Sprintf("ST_MaxY(%s)", geomColumn), db)
+	describeBBoxColumns(tableName, prefix, fmt.Sprintf("geometry column '%s'", geomColumn), db)
+}
+
+func addRelations(tables []Table, oafConfig OafConfig, report *Report, db *sql.DB) {
 	for _, table := range tables {
 		layerCfg, ok := oafConfig.getLayer(table.Name)
 		if !ok {
@@ -118,6 +269,8 @@ func addRelations(tables []Table, oafConfig OafConfig, db *sql.DB) {
 			for _, relation := range layerCfg.Relations {
 				log.Printf("Adding relation: %s -> %s.external_fid", relation.ColumnName(), relation.Table)
 				addColumn(table.Name, relation.ColumnName(), "TEXT", db)
+				describeColumn(table.Name, relation.ColumnName(), fmt.Sprintf("Reference to %s", relation.Table),
+					fmt.Sprintf("External feature id of the related feature in table '%s'", relation.Table), uuidConstraint, db)
 
 				if len(relation.Columns.Keys) < 1 {
 					log.Fatalf("relation '%s' must have at least one pk/fk defined", relation.ColumnName())
@@ -132,16 +285,14 @@ func addRelations(tables []Table, oafConfig OafConfig, db *sql.DB) {
 				}
 				executeQuery(fmt.Sprintf("update %s set %s = (select t.external_fid from %s t where %s)",
 					table.Name, relation.ColumnName(), relation.Table, whereClause), db)
+				report.table(table.Name).Relations = append(report.table(table.Name).Relations,
+					RelationReport{Table: relation.Table, Column: relation.ColumnName()})
 			}
 		}
 	}
 }
 
-func optimizeOWSGeopackage(sourceGeopackage string, config string) {
-	log.Printf("Performing OWS optimizations for geopackage: '%s'...\n", sourceGeopackage)
-	db := openDb(sourceGeopackage)
-	defer db.Close()
-
+func optimizeOWS(config string, report *Report, db *sql.DB) {
 	tables := readTables(db)
 
 	for _, table := range tables {
@@ -150,20 +301,18 @@ func optimizeOWSGeopackage(sourceGeopackage string, config string) {
 		addColumn(table.Name, columnName, "TEXT", db)
 		setColumnValue(table.Name, columnName, value, db)
 		createIndex(table.Name, []string{columnName}, "", true, db)
+		describeColumn(table.Name, columnName, "Persistent uuid", "Random UUID (version 4) of the feature", uuidConstraint, db)
 
 		columnName = "fuuid"
 		value = fmt.Sprintf("'%s.' || puuid", table.Name)
 		addColumn(table.Name, columnName, "TEXT", db)
 		setColumnValue(table.Name, columnName, value, db)
 		createIndex(table.Name, []string{columnName}, "", true, db)
+		describeColumn(table.Name, columnName, "Feature uuid", "Table name and persistent uuid of the feature: <table>.<puuid>", "", db)
 	}
 
 	if config != "" {
-		var owsConfig OwsConfig
-		err := json.Unmarshal([]byte(config), &owsConfig)
-		if err != nil {
-			log.Fatalf("cannot unmarshal ows config: %s", err)
-		}
+		owsConfig := parseOwsConfig(config)
 		if len(owsConfig.Indices) > 0 {
 			foundNames := make(map[string]bool)
 			for _, index := range owsConfig.Indices {
@@ -175,7 +324,31 @@ func optimizeOWSGeopackage(sourceGeopackage string, config string) {
 
 			for _, index := range owsConfig.Indices {
 				createIndex(index.Table, index.Columns, index.Name, index.Unique, db)
+				report.table(index.Table).Indexes = append(report.table(index.Table).Indexes,
+					IndexInspection{Name: index.Name, Unique: index.Unique, Columns: index.Columns})
+			}
+		}
+		if owsConfig.Validation != nil {
+			for _, table := range tables {
+				if table.IsFeatures {
+					validateGeometries(table, detectFidColumn(table.Name, db), *owsConfig.Validation, report, db)
+				}
 			}
 		}
+		for _, labelPoint := range owsConfig.LabelPoints {
+			table, ok := findTable(tables, labelPoint.Table)
+			if !ok {
+				log.Fatalf("label point table '%s' is not present in gpkg_contents", labelPoint.Table)
+			}
+			geomColumn := labelPoint.GeomColumn
+			if geomColumn == "" && table.IsFeatures {
+				geomColumn = Layer{}.geometryColumns(table)[0]
+			}
+			addLabelPoint(table, detectFidColumn(table.Name, db), geomColumn, labelPoint.LabelPoint, report, db)
+		}
+	}
+
+	for _, table := range tables {
+		reportOWSLayer(table, report, db)
 	}
 }
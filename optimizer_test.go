@@ -6,6 +6,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -453,3 +455,824 @@ func TestOptimizeOAFGeopackageFullConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestOptimizeOAFGeopackageQueryables(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "queryables":
+	      [
+	        {
+	          "name": "status",
+	          "case-insensitive": true
+	        },
+	        {
+	          "name": "bouwjaar"
+	        }
+	      ]
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check queryable indexes
+	for _, index := range []string{"pand_status_queryable_idx", "pand_bouwjaar_queryable_idx"} {
+		var exists int
+		err = db.QueryRow("select exists(select 1 from sqlite_master where type = 'index' and name = ? and tbl_name = 'pand') as index_exists;", index).Scan(&exists)
+		if err != nil {
+			log.Fatalf("error executing query: %s", err)
+		}
+		if exists != 1 {
+			log.Fatalf("index '%s' is missing", index)
+		}
+	}
+
+	// check queryables are recorded in report
+	queryables := report.table("pand").Queryables
+	if len(queryables) != 2 {
+		log.Fatalf("expected 2 queryables in report, got %d", len(queryables))
+	}
+	if !queryables[0].CaseInsensitive || queryables[1].Name != "bouwjaar" {
+		log.Fatalf("unexpected queryables in report: %v", queryables)
+	}
+}
+
+func TestOptimizeOAFGeopackageSearch(t *testing.T) {
+	memoryDb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer memoryDb.Close()
+	if !fts5Available(memoryDb) {
+		t.Skip("FTS5 is not available, run the tests with -tags sqlite_fts5")
+	}
+
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "external-fid-columns":
+	      [
+	        "identificatie"
+	      ],
+	      "search":
+	      {
+	        "columns":
+	        [
+	          "status"
+	        ]
+	      }
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check search index contains all features
+	var features, searchable int
+	err = db.QueryRow("select count(*) from pand;").Scan(&features)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	err = db.QueryRow("select count(*) from pand_search where external_fid is not null;").Scan(&searchable)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if features != searchable {
+		log.Fatalf("expected %d features in search index, got %d", features, searchable)
+	}
+
+	if report.table("pand").Search == nil || report.table("pand").Search.Table != "pand_search" {
+		log.Fatal("search index missing in report")
+	}
+}
+
+func TestOptimizeOAFGeopackageContents(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	optimizeOAFGeopackage(sourceGeopackage, "")
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check extent matches bbox of features
+	var minx, expectedMinx float64
+	err = db.QueryRow("select min_x from gpkg_contents where table_name = 'pand';").Scan(&minx)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	err = db.QueryRow("select min(minx) from pand;").Scan(&expectedMinx)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if minx != expectedMinx {
+		log.Fatalf("expected min_x %f in gpkg_contents, got %f", expectedMinx, minx)
+	}
+
+	// check feature count
+	var count, expectedCount int
+	err = db.QueryRow("select feature_count from gpkg_ogr_contents where table_name = 'pand';").Scan(&count)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	err = db.QueryRow("select count(*) from pand;").Scan(&expectedCount)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if count != expectedCount {
+		log.Fatalf("expected feature count %d in gpkg_ogr_contents, got %d", expectedCount, count)
+	}
+}
+
+func TestOptimizeOAFGeopackageMultipleGeometryColumns(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "sql-statements":
+	      [
+	        "ALTER TABLE pand ADD COLUMN centroid BLOB",
+	        "UPDATE pand SET centroid = geom"
+	      ],
+	      "geom-columns":
+	      [
+	        "geom",
+	        "centroid"
+	      ]
+	    }
+	  }
+	}`
+	optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check prefixed spatial columns equal those of primary geometry column
+	var mismatches int
+	err = db.QueryRow("select count(*) from pand where minx != centroid_minx or maxy != centroid_maxy;").Scan(&mismatches)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if mismatches != 0 {
+		log.Fatalf("expected bbox of centroid to match bbox of geom, got %d mismatches", mismatches)
+	}
+
+	// check spatial indexes
+	for _, index := range []string{"pand_spatial_idx", "pand_centroid_spatial_idx"} {
+		var exists int
+		err = db.QueryRow("select exists(select 1 from sqlite_master where type = 'index' and name = ? and tbl_name = 'pand') as index_exists;", index).Scan(&exists)
+		if err != nil {
+			log.Fatalf("error executing query: %s", err)
+		}
+		if exists != 1 {
+			log.Fatalf("index '%s' is missing", index)
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageBBoxCRS(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "bbox-crs":
+	      [
+	        4326
+	      ]
+	    }
+	  }
+	}`
+	optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check transformed bboxes are valid
+	var invalid int
+	err = db.QueryRow("select count(*) from pand where geom is not null and " +
+		"(epsg4326_minx is null or epsg4326_minx > epsg4326_maxx or epsg4326_miny > epsg4326_maxy or epsg4326_maxy > 90);").Scan(&invalid)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if invalid != 0 {
+		log.Fatalf("expected valid EPSG:4326 bboxes, got %d invalid bboxes", invalid)
+	}
+
+	// check spatial index
+	var exists int
+	err = db.QueryRow("select exists(select 1 from sqlite_master where type = 'index' and name = 'pand_epsg4326_spatial_idx' and tbl_name = 'pand') as index_exists;").Scan(&exists)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if exists != 1 {
+		log.Fatal("index 'pand_epsg4326_spatial_idx' is missing")
+	}
+}
+
+func TestOptimizeOAFGeopackageAdditionalCRS(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "additional-crs":
+	      [
+	        3857
+	      ]
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check every geometry is transformed, in a companion table joined by fid
+	var missing int
+	err = db.QueryRow("select count(*) from pand p left join pand_geom_epsg3857 c on c.fid = p.fid " +
+		"where p.geom is not null and (c.geom is null or c.minx is null);").Scan(&missing)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if missing != 0 {
+		log.Fatalf("expected all geometries to be transformed, got %d missing", missing)
+	}
+
+	// check the companion table is registered with an RTree index
+	var registered int
+	err = db.QueryRow("select count(*) from gpkg_geometry_columns g join gpkg_contents c using (table_name) " +
+		"where g.table_name = 'pand_geom_epsg3857' and g.column_name = 'geom' and g.srs_id = 3857 and c.data_type = 'features' " +
+		"and exists(select 1 from sqlite_master where name = 'rtree_pand_geom_epsg3857_geom');").Scan(&registered)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if registered != 1 {
+		log.Fatal("expected companion table 'pand_geom_epsg3857' to be registered with an RTree index")
+	}
+	if additionalCRS := report.table("pand").AdditionalCRS; len(additionalCRS) != 1 || additionalCRS[0].Table != "pand_geom_epsg3857" ||
+		additionalCRS[0].Index != "pand_geom_epsg3857_spatial_idx" {
+		log.Fatalf("unexpected additional CRS in report: %v", additionalCRS)
+	}
+
+	// check CRS is registered
+	var exists int
+	err = db.QueryRow("select exists(select 1 from gpkg_spatial_ref_sys where srs_id = 3857);").Scan(&exists)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if exists != 1 {
+		log.Fatal("CRS EPSG:3857 is missing in gpkg_spatial_ref_sys")
+	}
+}
+
+func TestOptimizeOAFGeopackageGeneralization(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "generalization":
+	      [
+	        {
+	          "tolerance": 0.5
+	        }
+	      ]
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check every geometry is simplified, in a companion table joined by fid
+	var missing int
+	err = db.QueryRow("select count(*) from pand p left join pand_geom_simplified_0_5 c on c.fid = p.fid " +
+		"where p.geom is not null and (c.geom is null or c.minx is null);").Scan(&missing)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if missing != 0 {
+		log.Fatalf("expected all geometries to be simplified, got %d missing", missing)
+	}
+
+	generalizations := report.table("pand").Generalizations
+	if len(generalizations) != 1 || generalizations[0].Table != "pand_geom_simplified_0_5" ||
+		generalizations[0].Index != "pand_geom_simplified_0_5_spatial_idx" {
+		log.Fatalf("unexpected generalizations in report: %v", generalizations)
+	}
+}
+
+func TestOptimizeOWSGeopackageTwice(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	if os.Getenv("OPTIMIZE_TWICE") == "1" {
+		// second run, in a separate process since it's expected to exit
+		optimizeOWSGeopackage(sourceGeopackage, "")
+		return
+	}
+	source, err := os.Open("testdata/original_ows.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	optimizeOWSGeopackage(sourceGeopackage, "")
+	checksum := fileChecksum(sourceGeopackage)
+
+	// the rerun is refused up front, leaving the GeoPackage untouched
+	cmd := exec.Command(os.Args[0], "-test.run=^TestOptimizeOWSGeopackageTwice$")
+	cmd.Env = append(os.Environ(), "OPTIMIZE_TWICE=1")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "already optimized") {
+		log.Fatalf("expected the second run to be refused, got %v: %s", err, output)
+	}
+	if fileChecksum(sourceGeopackage) != checksum {
+		log.Fatal("expected the GeoPackage to be untouched by the second run")
+	}
+}
+
+func TestOptimizeOWSGeopackageLabelPoints(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_ows.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	optimizeOWSGeopackage(sourceGeopackage, `{"label-points":[{"table": "layer", "geometry": true}]}`)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// label point of a point geometry is the point itself
+	var mismatches int
+	err = db.QueryRow("select count(*) from layer l left join layer_label_point c on c.fid = l.fid where l.geom is not null and " +
+		"(l.label_x != ST_MinX(l.geom) or l.label_y != ST_MinY(l.geom) or ST_MinX(c.geom) != l.label_x);").Scan(&mismatches)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if mismatches != 0 {
+		log.Fatalf("expected label points to equal point geometries, got %d mismatches", mismatches)
+	}
+
+	var exists int
+	err = db.QueryRow("select exists(select 1 from sqlite_master where type = 'index' and name = 'layer_label_idx' and tbl_name = 'layer') as index_exists;").Scan(&exists)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if exists != 1 {
+		log.Fatal("index 'layer_label_idx' is missing")
+	}
+}
+
+func TestOptimizeOAFGeopackageValidation(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "sql-statements":
+	      [
+	        "UPDATE pand SET geom = NULL WHERE fid = (select min(fid) from pand)"
+	      ],
+	      "validation":
+	      {
+	        "action": "exclude"
+	      }
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	var nullGeometries int
+	err = db.QueryRow("select count(*) from pand where geom is null;").Scan(&nullGeometries)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if nullGeometries != 0 {
+		log.Fatalf("expected features without geometry to be excluded, got %d", nullGeometries)
+	}
+
+	invalid := report.table("pand").InvalidGeometries
+	if len(invalid) < 1 || invalid[0].Column != "geom" || invalid[0].Reason != "null geometry" || invalid[0].Action != "exclude" {
+		log.Fatalf("unexpected invalid geometries in report: %v", invalid)
+	}
+}
+
+func TestRevertGeopackage(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+	originalColumns := readColumns("pand", db)
+	originalIndexes := readIndexes("pand", db)
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "external-fid-columns":
+	      [
+	        "identificatie"
+	      ],
+	      "label-point": {}
+	    }
+	  }
+	}`
+	optimizeOAFGeopackage(sourceGeopackage, config)
+	revertGeopackage(sourceGeopackage)
+
+	columns := readColumns("pand", db)
+	if len(columns) != len(originalColumns) {
+		log.Fatalf("expected original columns %v, got %v", originalColumns, columns)
+	}
+	if indexes := readIndexes("pand", db); len(indexes) != len(originalIndexes) {
+		log.Fatalf("expected original indexes %v, got %v", originalIndexes, indexes)
+	}
+}
+
+func TestOptimizeTilesGeopackage(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_ows.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "layer":
+	    {
+	      "tile-matrix-sets": ["NetherlandsRDNewQuad", "WebMercatorQuad"],
+	      "max-zoom": 3,
+	      "simplified-zoom-levels": [1],
+	      "attributes": ["name"]
+	    }
+	  }
+	}`
+	report := optimizeGeopackage(sourceGeopackage, "tiles", config, optimizeOptions{})
+
+	tilesReport := report.Tables["layer"].Tiles
+	if tilesReport == nil || len(tilesReport.TileMatrixSets) != 2 || len(tilesReport.Zooms) != 1 ||
+		tilesReport.Zooms[0].Table != "layer_geom_z1" || tilesReport.View != "layer_tiles" {
+		log.Fatalf("unexpected tiles report %v", tilesReport)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	var names []string
+	for _, column := range readColumns("layer_tiles", db) {
+		names = append(names, column.Name)
+	}
+	expected := "fid,name,geom,geom_z1," +
+		"netherlandsrdnewquad_tile_min_x,netherlandsrdnewquad_tile_max_x,netherlandsrdnewquad_tile_min_y,netherlandsrdnewquad_tile_max_y," +
+		"webmercatorquad_tile_min_x,webmercatorquad_tile_max_x,webmercatorquad_tile_min_y,webmercatorquad_tile_max_y"
+	if strings.Join(names, ",") != expected {
+		log.Fatalf("expected view columns %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	// at zoom 3 the Netherlands is covered by a few tiles of WebMercatorQuad
+	var outside int
+	err = db.QueryRow("select count(*) from layer where geom is not null and " +
+		"(webmercatorquad_tile_min_x != 4 or webmercatorquad_tile_min_y != 2)").Scan(&outside)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if outside != 0 {
+		log.Fatalf("expected features in WebMercatorQuad tile 3/4/2, got %d features outside", outside)
+	}
+}
+
+func TestOptimizeTilesGeopackageWithoutLayerConfig(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_ows.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	// feature table 'layer' has no config, so it gets no bbox columns and its extent is kept
+	report := optimizeGeopackage(sourceGeopackage, "tiles", `{"layers":{}}`, optimizeOptions{})
+	if tableReport, ok := report.Tables["layer"]; ok && (tableReport.Tiles != nil || tableReport.Extent != nil) {
+		log.Fatalf("expected table 'layer' to be skipped, got %+v", tableReport)
+	}
+}
+
+func TestOptimizeOAFGeopackageAttributeTable(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	// add an attribute (non-spatial) table referring to pand
+	db := openDb(sourceGeopackage)
+	executeQuery("CREATE TABLE registratie (fid INTEGER PRIMARY KEY, code TEXT, pand_id TEXT, geldig_vanaf DATE)", db)
+	executeQuery("INSERT INTO registratie (code, pand_id, geldig_vanaf) SELECT 'R' || fid, identificatie, '2020-01-01' FROM pand", db)
+	executeQuery("INSERT INTO gpkg_contents (table_name, data_type, identifier) VALUES ('registratie', 'attributes', 'registratie')", db)
+	db.Close()
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "external-fid-columns": ["identificatie"]
+	    },
+	    "registratie":
+	    {
+	      "external-fid-columns": ["code"],
+	      "temporal-columns": ["geldig_vanaf"],
+	      "queryables": [{"name": "code"}],
+	      "label-point": {},
+	      "relations": [{"table": "pand", "columns": {"keys": [{"fk": "pand_id", "pk": "identificatie"}]}}]
+	    }
+	  }
+	}`
+	report := optimizeOAFGeopackage(sourceGeopackage, config)
+
+	tableReport := report.Tables["registratie"]
+	if tableReport.DataType != "attributes" || tableReport.ExternalFid != "external_fid" || len(tableReport.Queryables) != 1 ||
+		len(tableReport.Relations) != 1 || tableReport.Extent != nil {
+		log.Fatalf("unexpected report for attribute table %+v", tableReport)
+	}
+	if strings.Join(tableReport.OmittedOptimizations, ",") != "bbox,spatial-index,label-point" {
+		log.Fatalf("unexpected omitted optimizations %v", tableReport.OmittedOptimizations)
+	}
+
+	db, err = sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	if columnExists("registratie", "minx", db) || columnExists("registratie", "label_x", db) {
+		log.Fatal("expected no spatial columns in attribute table 'registratie'")
+	}
+	var unrelated int
+	err = db.QueryRow("select count(*) from registratie r where pand_external_fid is null or " +
+		"pand_external_fid != (select external_fid from pand p where p.identificatie = r.pand_id)").Scan(&unrelated)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if unrelated != 0 {
+		log.Fatalf("expected every registratie to refer to the external fid of its pand, got %d without", unrelated)
+	}
+}
+
+func TestOptimizeOAFGeopackageCluster(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		log.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+	fids := strings.Join(queryStrings("select fid from pand order by fid", db), ",")
+
+	report := optimizeGeopackage(sourceGeopackage, "oaf", "", optimizeOptions{cluster: curveHilbert})
+	if clustering := report.Tables["pand"].Clustering; clustering == nil || clustering.OriginalFid != "original_fid" {
+		log.Fatalf("unexpected clustering report %v", clustering)
+	}
+
+	// the fids are renumbered from 1, every original fid is kept
+	var count, maxFid int64
+	err = db.QueryRow("select count(*), max(fid) from pand").Scan(&count, &maxFid)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if maxFid != count {
+		log.Fatalf("expected fids 1 to %d, got max fid %d", count, maxFid)
+	}
+	if originalFids := strings.Join(queryStrings("select original_fid from pand order by original_fid", db), ","); originalFids != fids {
+		log.Fatalf("expected the original fids to be kept, got %s instead of %s", originalFids, fids)
+	}
+
+	// the rows are stored along the curve, features without geometry last
+	var extent [4]float64
+	err = db.QueryRow("select min(ST_MinX(geom)), min(ST_MinY(geom)), max(ST_MaxX(geom)), max(ST_MaxY(geom)) from pand").
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	key := curveKeyExpression(curveHilbert, "(ST_MinX(geom) + ST_MaxX(geom)) / 2", "(ST_MinY(geom) + ST_MaxY(geom)) / 2", extent)
+	var unordered int
+	err = db.QueryRow(fmt.Sprintf("select count(*) from (select key, lag(key) over (order by rowid) as previous, "+
+		"row_number() over (order by rowid) as number from (select rowid, %s as key from pand)) "+
+		"where key < previous or (previous is null and key is not null and number > 1)", key)).Scan(&unordered)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if unordered != 0 {
+		log.Fatalf("expected the rows to be stored along the curve, got %d rows out of order", unordered)
+	}
+
+	// the RTree is updated by its triggers
+	var mismatches int
+	err = db.QueryRow("select count(*) from rtree_pand_geom r left join pand p on p.fid = r.id " +
+		"where p.fid is null or r.minx > ST_MinX(p.geom) or r.maxx < ST_MaxX(p.geom)").Scan(&mismatches)
+	if err != nil {
+		log.Fatalf("error executing query: %s", err)
+	}
+	if mismatches != 0 {
+		log.Fatalf("expected the RTree to follow the renumbered fids, got %d mismatches", mismatches)
+	}
+
+	// revert restores the original fids
+	revertArtifacts(db)
+	if reverted := strings.Join(queryStrings("select fid from pand order by fid", db), ","); reverted != fids {
+		log.Fatalf("expected the original fids after revert, got %s instead of %s", reverted, fids)
+	}
+	if columnExists("pand", "original_fid", db) {
+		log.Fatal("expected column 'original_fid' to be removed by revert")
+	}
+}
@@ -1,7 +1,36 @@
 package main
 
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/creasty/defaults"
+)
+
 type OwsConfig struct {
-	Indices []ManualIndex `json:"indices"`
+	Indices     []ManualIndex   `json:"indices"`
+	LabelPoints []OwsLabelPoint `json:"label-points"`
+	Validation  *Validation     `json:"validation"`
+}
+
+type OwsLabelPoint struct {
+	Table      string `json:"table"`
+	GeomColumn string `json:"geom-column"`
+	LabelPoint
+}
+
+// parseOwsConfig unmarshals the given JSON config
+func parseOwsConfig(config string) OwsConfig {
+	var owsConfig OwsConfig
+	err := json.Unmarshal([]byte(config), &owsConfig)
+	if err != nil {
+		log.Fatalf("cannot unmarshal ows config: %s", err)
+	}
+	err = defaults.Set(&owsConfig)
+	if err != nil {
+		log.Fatalf("failed to set default config: %s", err)
+	}
+	return owsConfig
 }
 
 type ManualIndex struct {
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// provenanceTable records every optimization run, so it's known whether and how a GeoPackage was optimized
+const provenanceTable = "pdok_optimizer_provenance"
+
+const provenanceURI = "https://github.com/PDOK/geopackage-optimizer-go"
+
+// version of the optimizer, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+type Provenance struct {
+	OptimizerVersion string     `json:"optimizer-version"`
+	ServiceType      string     `json:"service-type"`
+	Config           string     `json:"config"`
+	Timestamp        string     `json:"timestamp"`
+	SourceChecksum   string     `json:"source-checksum"`
+	Artifacts        []Artifact `json:"artifacts"`
+}
+
+// fileChecksum returns the SHA-256 checksum of the given file
+func fileChecksum(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("error opening '%s': %s", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		log.Fatalf("error computing checksum of '%s': %s", path, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// readLatestProvenance returns the provenance of the most recent optimization run, if any
+func readLatestProvenance(db *sql.DB) (Provenance, bool) {
+	var provenance Provenance
+	if !tableExists(provenanceTable, db) {
+		return provenance, false
+	}
+	var artifacts string
+	err := db.QueryRow(fmt.Sprintf("select optimizer_version, service_type, config, timestamp, source_checksum, artifacts "+
+		"from %s order by id desc limit 1", provenanceTable)).Scan(&provenance.OptimizerVersion, &provenance.ServiceType,
+		&provenance.Config, &provenance.Timestamp, &provenance.SourceChecksum, &artifacts)
+	if err != nil {
+		log.Fatalf("error reading %s: %s", provenanceTable, err)
+	}
+	err = json.Unmarshal([]byte(artifacts), &provenance.Artifacts)
+	if err != nil {
+		log.Fatalf("cannot unmarshal artifacts of %s: %s", provenanceTable, err)
+	}
+	return provenance, true
+}
+
+// checkReoptimization refuses to optimize an already optimized GeoPackage, unless forced. Rerunning the same
+// optimizations would fail halfway (e.g. on adding an existing column), leaving the GeoPackage half-modified.
+func checkReoptimization(serviceType string, effectiveConfig string, force bool, db *sql.DB) error {
+	previous, ok := readLatestProvenance(db)
+	if !ok {
+		return nil
+	}
+	var message string
+	if previous.ServiceType == serviceType && previous.Config == effectiveConfig {
+		message = fmt.Sprintf("GeoPackage was already optimized at %s with the same service type and config", previous.Timestamp)
+	} else {
+		message = fmt.Sprintf("GeoPackage was already optimized at %s for service type '%s' with a different config: %s",
+			previous.Timestamp, previous.ServiceType, previous.Config)
+	}
+	if !force {
+		return fmt.Errorf("%s, revert it first (use -force to optimize anyway)", message)
+	}
+	log.Printf("WARNING: %s", message)
+	return nil
+}
+
+// writeProvenance records the given optimization run, including the artifacts created since the given artifact id.
+// Optionally the provenance is also added to gpkg_metadata (metadata extension).
+func writeProvenance(provenance Provenance, sinceArtifactID int64, gpkgMetadata bool, db *sql.DB) {
+	provenance.Artifacts = readArtifactsSince(sinceArtifactID, db)
+	artifacts, err := json.Marshal(provenance.Artifacts)
+	if err != nil {
+		log.Fatalf("cannot marshal artifacts: %s", err)
+	}
+
+	if !tableExists(provenanceTable, db) {
+		executeQuery(fmt.Sprintf("CREATE TABLE %s (id INTEGER PRIMARY KEY AUTOINCREMENT, optimizer_version TEXT NOT NULL, "+
+			"service_type TEXT NOT NULL, config TEXT NOT NULL, timestamp DATETIME NOT NULL, source_checksum TEXT NOT NULL, "+
+			"artifacts TEXT NOT NULL)", provenanceTable), db)
+		recordArtifact("", artifactTable, provenanceTable, db)
+	}
+	_, err = db.Exec(fmt.Sprintf("insert into %s (optimizer_version, service_type, config, timestamp, source_checksum, artifacts) "+
+		"values (?, ?, ?, ?, ?, ?)", provenanceTable), provenance.OptimizerVersion, provenance.ServiceType, provenance.Config,
+		provenance.Timestamp, provenance.SourceChecksum, string(artifacts))
+	if err != nil {
+		log.Fatalf("error writing %s: %s", provenanceTable, err)
+	}
+
+	if gpkgMetadata {
+		writeGpkgMetadata(provenance, db)
+	}
+}
+
+// writeGpkgMetadata adds the provenance as GeoPackage-wide JSON metadata to gpkg_metadata
+func writeGpkgMetadata(provenance Provenance, db *sql.DB) {
+	if !tableExists("gpkg_metadata", db) {
+		executeQuery("CREATE TABLE gpkg_metadata (id INTEGER CONSTRAINT m_pk PRIMARY KEY ASC NOT NULL, "+
+			"md_scope TEXT NOT NULL DEFAULT 'dataset', md_standard_uri TEXT NOT NULL, mime_type TEXT NOT NULL DEFAULT 'text/xml', "+
+			"metadata TEXT NOT NULL DEFAULT '')", db)
+		recordArtifact("", artifactTable, "gpkg_metadata", db)
+	}
+	if !tableExists("gpkg_metadata_reference", db) {
+		executeQuery("CREATE TABLE gpkg_metadata_reference (reference_scope TEXT NOT NULL, table_name TEXT, column_name TEXT, "+
+			"row_id_value INTEGER, timestamp DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), "+
+			"md_file_id INTEGER NOT NULL, md_parent_id INTEGER, "+
+			"CONSTRAINT crmr_mfi_fk FOREIGN KEY (md_file_id) REFERENCES gpkg_metadata(id), "+
+			"CONSTRAINT crmr_mpi_fk FOREIGN KEY (md_parent_id) REFERENCES gpkg_metadata(id))", db)
+		recordArtifact("", artifactTable, "gpkg_metadata_reference", db)
+	}
+	registerExtension("gpkg_metadata", "", "gpkg_metadata", "http://www.geopackage.org/spec/#extension_metadata", "read-write", db)
+	registerExtension("gpkg_metadata_reference", "", "gpkg_metadata", "http://www.geopackage.org/spec/#extension_metadata", "read-write", db)
+
+	content, err := json.Marshal(provenance)
+	if err != nil {
+		log.Fatalf("cannot marshal provenance: %s", err)
+	}
+	result, err := db.Exec("insert into gpkg_metadata (md_scope, md_standard_uri, mime_type, metadata) values ('dataset', ?, 'application/json', ?)",
+		provenanceURI, string(content))
+	if err != nil {
+		log.Fatalf("error writing gpkg_metadata: %s", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec("insert into gpkg_metadata_reference (reference_scope, md_file_id) values ('geopackage', ?)", id)
+	if err != nil {
+		log.Fatalf("error writing gpkg_metadata_reference: %s", err)
+	}
+	recordArtifact("", artifactMetadata, fmt.Sprint(id), db)
+}
+
+func newProvenance(serviceType string, effectiveConfig string, sourceChecksum string) Provenance {
+	return Provenance{
+		OptimizerVersion: version,
+		ServiceType:      serviceType,
+		Config:           effectiveConfig,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		SourceChecksum:   sourceChecksum,
+	}
+}
+
+// marshalConfig returns the given parsed config as JSON
+func marshalConfig(config any) string {
+	content, err := json.Marshal(config)
+	if err != nil {
+		log.Fatalf("cannot marshal config: %s", err)
+	}
+	return string(content)
+}
+
+// revertMetadata removes a gpkg_metadata entry added by the optimizer, including its references
+func revertMetadata(id string, db *sql.DB) {
+	if !tableExists("gpkg_metadata", db) {
+		return
+	}
+	if tableExists("gpkg_metadata_reference", db) {
+		_, err := db.Exec("delete from gpkg_metadata_reference where md_file_id = ?", id)
+		if err != nil {
+			log.Fatalf("error removing gpkg_metadata_reference of metadata %s: %s", id, err)
+		}
+	}
+	_, err := db.Exec("delete from gpkg_metadata where id = ?", id)
+	if err != nil {
+		log.Fatalf("error removing gpkg_metadata %s: %s", id, err)
+	}
+}
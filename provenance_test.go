@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWriteProvenance(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, identificatie TEXT)", db)
+	if _, ok := readLatestProvenance(db); ok {
+		log.Fatalf("expected no provenance before optimizing")
+	}
+
+	lastArtifactID := readLastArtifactID(db)
+	addColumn("pand", "external_fid", "TEXT", db)
+	createIndex("pand", []string{"external_fid"}, "pand_external_fid_idx", false, db)
+	config := `{"layers":{"pand":{"validation":{"action":"report"}}}}`
+	writeProvenance(newProvenance("oaf", config, "sha256:abc"), lastArtifactID, true, db)
+
+	provenance, ok := readLatestProvenance(db)
+	if !ok {
+		log.Fatalf("expected provenance to be recorded")
+	}
+	if provenance.ServiceType != "oaf" || provenance.Config != config || provenance.OptimizerVersion != version {
+		log.Fatalf("unexpected provenance %v", provenance)
+	}
+	if len(provenance.Artifacts) != 2 || provenance.Artifacts[0].Type != artifactColumn || provenance.Artifacts[1].Type != artifactIndex {
+		log.Fatalf("expected column and index artifacts in order of creation, got %v", provenance.Artifacts)
+	}
+
+	var metadataCount int
+	err = db.QueryRow("select count(*) from gpkg_metadata m join gpkg_metadata_reference r on r.md_file_id = m.id " +
+		"where m.mime_type = 'application/json' and r.reference_scope = 'geopackage'").Scan(&metadataCount)
+	if err != nil || metadataCount != 1 {
+		log.Fatalf("expected provenance in gpkg_metadata, got %d (%v)", metadataCount, err)
+	}
+
+	revertArtifacts(db)
+	for _, table := range []string{provenanceTable, "gpkg_metadata", "gpkg_metadata_reference", "gpkg_extensions"} {
+		if tableExists(table, db) {
+			log.Fatalf("expected table '%s' to be removed", table)
+		}
+	}
+}
+
+func TestCheckReoptimization(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	config := `{"layers":{}}`
+	if err = checkReoptimization("oaf", config, false, db); err != nil {
+		log.Fatalf("expected a GeoPackage that was never optimized to be accepted, got %s", err)
+	}
+	writeProvenance(newProvenance("oaf", config, "sha256:abc"), readLastArtifactID(db), false, db)
+
+	// a rerun is refused, with the same config as well as with another service type or config
+	if err = checkReoptimization("oaf", config, false, db); err == nil || !strings.Contains(err.Error(), "same service type and config") {
+		log.Fatalf("expected a rerun with the same config to be refused, got %v", err)
+	}
+	if err = checkReoptimization("ows", "", false, db); err == nil || !strings.Contains(err.Error(), "different config") {
+		log.Fatalf("expected a rerun with another service type to be refused, got %v", err)
+	}
+	if err = checkReoptimization("oaf", config, true, db); err != nil {
+		log.Fatalf("expected a forced rerun to be accepted, got %s", err)
+	}
+}
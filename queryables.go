@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2019-09/schema"
+
+// QueryablesDocument is a queryables JSON schema as defined by OGC API Features Part 3
+type QueryablesDocument struct {
+	Schema               string                       `json:"$schema"`
+	Type                 string                       `json:"type"`
+	Title                string                       `json:"title"`
+	Properties           map[string]QueryableProperty `json:"properties"`
+	AdditionalProperties bool                         `json:"additionalProperties"`
+}
+
+type QueryableProperty struct {
+	Title  string `json:"title"`
+	Type   string `json:"type"`
+	Format string `json:"format,omitempty"`
+}
+
+// addQueryables creates an index for every queryable of the given table, so features can be filtered
+// efficiently on these properties. Each queryable is recorded in the report.
+func addQueryables(table Table, queryables []Queryable, report *Report, db *sql.DB) {
+	columns := readColumns(table.Name, db)
+	for _, queryable := range queryables {
+		if queryable.Name == "" {
+			log.Fatalf("queryable of table '%s' must have a name", table.Name)
+		}
+		column, isColumn := findColumn(columns, queryable.Name)
+		if !isColumn && queryable.Expression == "" {
+			log.Fatalf("queryable '%s' is not a column of table '%s', an expression is required", queryable.Name, table.Name)
+		}
+
+		expression := queryable.Name
+		if queryable.Expression != "" {
+			expression = queryable.Expression
+		}
+		if queryable.CaseInsensitive {
+			expression += " COLLATE NOCASE"
+		}
+		indexName := fmt.Sprintf("%s_%s_queryable_idx", table.Name, queryable.Name)
+		createIndex(table.Name, []string{expression}, indexName, false, db)
+
+		schemaType, format := "string", ""
+		if isColumn {
+			schemaType, format = jsonSchemaType(column.Type)
+		}
+		if queryable.Type != "" {
+			schemaType, format = queryable.Type, ""
+		}
+		report.table(table.Name).Queryables = append(report.table(table.Name).Queryables, QueryableReport{
+			Name:            queryable.Name,
+			Type:            schemaType,
+			Format:          format,
+			Index:           indexName,
+			CaseInsensitive: queryable.CaseInsensitive,
+		})
+	}
+}
+
+// jsonSchemaType maps the declared type of a SQLite column to a JSON schema type and format
+func jsonSchemaType(sqliteType string) (string, string) {
+	sqliteType = strings.ToUpper(sqliteType)
+	if i := strings.Index(sqliteType, "("); i >= 0 {
+		sqliteType = sqliteType[:i]
+	}
+	switch {
+	case strings.Contains(sqliteType, "INT"):
+		return "integer", ""
+	case sqliteType == "BOOLEAN":
+		return "boolean", ""
+	case sqliteType == "DATETIME":
+		return "string", "date-time"
+	case sqliteType == "DATE":
+		return "string", "date"
+	case sqliteType == "REAL", sqliteType == "DOUBLE", sqliteType == "FLOAT",
+		sqliteType == "NUMERIC", strings.HasPrefix(sqliteType, "DECIMAL"):
+		return "number", ""
+	default:
+		return "string", ""
+	}
+}
+
+func findColumn(columns []Column, name string) (Column, bool) {
+	for _, column := range columns {
+		if strings.EqualFold(column.Name, name) {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// writeQueryables writes a queryables document for every table in the report that has queryables,
+// keyed by table (collection) name
+func writeQueryables(report *Report, path string) {
+	documents := make(map[string]QueryablesDocument)
+	for tableName, tableReport := range report.Tables {
+		if len(tableReport.Queryables) == 0 {
+			continue
+		}
+		document := QueryablesDocument{
+			Schema:     jsonSchemaDialect,
+			Type:       "object",
+			Title:      tableName,
+			Properties: make(map[string]QueryableProperty),
+		}
+		for _, queryable := range tableReport.Queryables {
+			document.Properties[queryable.Name] = QueryableProperty{
+				Title:  queryable.Name,
+				Type:   queryable.Type,
+				Format: queryable.Format,
+			}
+		}
+		documents[tableName] = document
+	}
+	log.Printf("Writing queryables to '%s'", path)
+	writeJSON(documents, path)
+}
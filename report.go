@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// Report records the outcome of an optimization run, so downstream tooling (e.g. GoKoala) can
+// find out which optimizations are available in the GeoPackage.
+type Report struct {
+	Geopackage  string                  `json:"geopackage"`
+	ServiceType string                  `json:"service-type"`
+	Tables      map[string]*TableReport `json:"tables"`
+	Merge       *MergeReport            `json:"merge,omitempty"`
+}
+
+type TableReport struct {
+	DataType             string                  `json:"data-type,omitempty"`
+	FidColumn            string                  `json:"fid-column,omitempty"`
+	GeomColumn           string                  `json:"geom-column,omitempty"`
+	GeometryType         string                  `json:"geometry-type,omitempty"`
+	SrsID                int64                   `json:"srs-id,omitempty"`
+	CRS                  string                  `json:"crs,omitempty"`
+	ExternalFid          string                  `json:"external-fid,omitempty"`
+	TemporalColumns      []string                `json:"temporal-columns,omitempty"`
+	Relations            []RelationReport        `json:"relations,omitempty"`
+	Indexes              []IndexInspection       `json:"indexes,omitempty"`
+	FeatureCount         int64                   `json:"feature-count"`
+	Extent               []float64               `json:"extent,omitempty"`
+	InvalidGeometries    []InvalidGeometryReport `json:"invalid-geometries,omitempty"`
+	BBoxes               []BBoxReport            `json:"bboxes,omitempty"`
+	AdditionalCRS        []CRSGeometryReport     `json:"additional-crs,omitempty"`
+	Generalizations      []GeneralizationReport  `json:"generalization,omitempty"`
+	LabelPoint           *LabelPointReport       `json:"label-point,omitempty"`
+	Queryables           []QueryableReport       `json:"queryables,omitempty"`
+	Search               *SearchReport           `json:"search,omitempty"`
+	Tiles                *TilesReport            `json:"tiles,omitempty"`
+	VectorTiles          *VectorTilesReport      `json:"vector-tiles,omitempty"`
+	TilePyramid          *TilePyramidReport      `json:"tile-pyramid,omitempty"`
+	Clustering           *ClusteringReport       `json:"clustering,omitempty"`
+	CurveKey             *CurveKeyReport         `json:"curve-key,omitempty"`
+	OmittedOptimizations []string                `json:"omitted-optimizations,omitempty"`
+}
+
+type InvalidGeometryReport struct {
+	Fid    string `json:"fid"`
+	Column string `json:"column"`
+	Reason string `json:"reason"`
+	Action string `json:"action"`
+}
+
+type RelationReport struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+}
+
+type BBoxReport struct {
+	CRS    int64  `json:"crs"`
+	Prefix string `json:"prefix"`
+	Index  string `json:"index"`
+}
+
+type CRSGeometryReport struct {
+	CRS int64 `json:"crs"`
+	// companion table containing the geometry column
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Index  string `json:"index"`
+}
+
+type GeneralizationReport struct {
+	Tolerance float64 `json:"tolerance"`
+	// companion table containing the geometry column
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Index  string `json:"index"`
+}
+
+type LabelPointReport struct {
+	X string `json:"x"`
+	Y string `json:"y"`
+	// companion table containing the label point geometry
+	Table    string `json:"table,omitempty"`
+	Geometry string `json:"geometry,omitempty"`
+	Index    string `json:"index"`
+}
+
+type QueryableReport struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	Format          string `json:"format,omitempty"`
+	Index           string `json:"index"`
+	CaseInsensitive bool   `json:"case-insensitive,omitempty"`
+}
+
+type SearchReport struct {
+	Table     string   `json:"table"`
+	Columns   []string `json:"columns"`
+	Payload   []string `json:"payload"`
+	Tokenizer string   `json:"tokenizer"`
+}
+
+type ClusteringReport struct {
+	Curve       string `json:"curve"`
+	OriginalFid string `json:"original-fid"`
+	Index       string `json:"index"`
+}
+
+type CurveKeyReport struct {
+	Curve  string    `json:"curve"`
+	Order  int       `json:"order"`
+	Extent []float64 `json:"extent"`
+	Column string    `json:"column"`
+	Index  string    `json:"index"`
+}
+
+type TilesReport struct {
+	MinZoom        int                     `json:"min-zoom"`
+	MaxZoom        int                     `json:"max-zoom"`
+	TileMatrixSets []TileCoordinatesReport `json:"tile-matrix-sets"`
+	Zooms          []ZoomGeometryReport    `json:"zooms,omitempty"`
+	Attributes     []string                `json:"attributes,omitempty"`
+	View           string                  `json:"view"`
+}
+
+type VectorTilesReport struct {
+	TileMatrixSet string   `json:"tile-matrix-set"`
+	MinZoom       int      `json:"min-zoom"`
+	MaxZoom       int      `json:"max-zoom"`
+	Layers        []string `json:"layers"`
+	TileCount     int64    `json:"tile-count"`
+}
+
+type TilePyramidReport struct {
+	UniqueIndex    string `json:"unique-index,omitempty"`
+	DuplicateTiles int64  `json:"duplicate-tiles"`
+	// tile coordinates (zoom level, tile column, tile row) with different tiles
+	ConflictingTiles  [][3]int             `json:"conflicting-tiles,omitempty"`
+	ZoomLevels        []int                `json:"zoom-levels"`
+	MissingZoomLevels []int                `json:"missing-zoom-levels,omitempty"`
+	UnknownZoomLevels []int                `json:"unknown-zoom-levels,omitempty"`
+	OutOfBoundsTiles  int64                `json:"out-of-bounds-tiles"`
+	Recompression     *RecompressionReport `json:"recompression,omitempty"`
+}
+
+type RecompressionReport struct {
+	Tiles       int64 `json:"tiles"`
+	Skipped     int64 `json:"skipped"`
+	BytesBefore int64 `json:"bytes-before"`
+	BytesAfter  int64 `json:"bytes-after"`
+}
+
+type TileCoordinatesReport struct {
+	TileMatrixSet string `json:"tile-matrix-set"`
+	Zoom          int    `json:"zoom"`
+	Prefix        string `json:"prefix"`
+	Index         string `json:"index"`
+}
+
+type ZoomGeometryReport struct {
+	TileMatrixSet string `json:"tile-matrix-set"`
+	Zoom          int    `json:"zoom"`
+	// companion table containing the geometry column
+	Table     string  `json:"table"`
+	Column    string  `json:"column"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+func newReport(geopackage string, serviceType string) *Report {
+	return &Report{
+		Geopackage:  geopackage,
+		ServiceType: serviceType,
+		Tables:      make(map[string]*TableReport),
+	}
+}
+
+// table returns the report of the given table, creating it when needed
+func (r *Report) table(tableName string) *TableReport {
+	if _, ok := r.Tables[tableName]; !ok {
+		r.Tables[tableName] = &TableReport{}
+	}
+	return r.Tables[tableName]
+}
+
+// layer records the data type and the resolved fid, geometry and temporal columns of the given table
+func (t *TableReport) layer(table Table, layerCfg Layer) {
+	t.DataType = table.DataType
+	t.FidColumn = layerCfg.FidColumn
+	t.GeomColumn = layerCfg.GeomColumn
+	t.TemporalColumns = layerCfg.TemporalColumns
+	if geomColumn, ok := table.geometryColumn(layerCfg.GeomColumn); ok {
+		t.SrsID = geomColumn.SrsID
+	}
+}
+
+func writeReport(report any, path string) {
+	log.Printf("Writing report to '%s'", path)
+	writeJSON(report, path)
+}
+
+func writeJSON(v any, path string) {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("cannot marshal JSON for '%s': %s", path, err)
+	}
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		log.Fatalf("cannot write '%s': %s", path, err)
+	}
+}
+
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		log.Fatalf("cannot marshal JSON: %s", err)
+	}
+}
+
+type MergeReport struct {
+	Inputs []string                     `json:"inputs"`
+	Tables map[string]*MergeTableReport `json:"tables"`
+}
+
+type MergeTableReport struct {
+	Rows              int64    `json:"rows"`
+	ReassignedFids    int64    `json:"reassigned-fids"`
+	TransformedInputs []string `json:"transformed-inputs,omitempty"`
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// addSearchIndex creates an FTS5 virtual table over the configured columns of the given table, to support free-text
+// search. The virtual table uses the feature table as external content, so only the full-text index is stored.
+// External feature id and bbox are included as (unindexed) payload, so search results can be returned and
+// filtered spatially without a join. Triggers keep the index in sync with the feature table.
+func addSearchIndex(table Table, layerCfg Layer, report *Report, db *sql.DB) {
+	search := layerCfg.Search
+	if len(search.Columns) < 1 {
+		log.Fatalf("search index of table '%s' must have at least one column defined", table.Name)
+	}
+	if !fts5Available(db) {
+		log.Fatalf("search index of table '%s' requires FTS5, build the optimizer with -tags sqlite_fts5", table.Name)
+	}
+	searchTable := fmt.Sprintf("%s_search", table.Name)
+
+	var payload []string
+	if layerCfg.ExternalFidColumns != nil {
+		payload = append(payload, "external_fid")
+	}
+	if table.IsFeatures {
+		payload = append(payload, "minx", "maxx", "miny", "maxy")
+	}
+	columns := append(append([]string{}, search.Columns...), payload...)
+
+	definition := make([]string, 0, len(columns)+3)
+	definition = append(definition, search.Columns...)
+	for _, column := range payload {
+		definition = append(definition, column+" UNINDEXED")
+	}
+	definition = append(definition,
+		fmt.Sprintf("content='%s'", table.Name),
+		fmt.Sprintf("content_rowid='%s'", layerCfg.FidColumn),
+		fmt.Sprintf("tokenize='%s'", search.tokenizer()),
+	)
+	if len(search.Prefix) > 0 {
+		prefixes := make([]string, 0, len(search.Prefix))
+		for _, prefix := range search.Prefix {
+			prefixes = append(prefixes, fmt.Sprint(prefix))
+		}
+		definition = append(definition, fmt.Sprintf("prefix='%s'", strings.Join(prefixes, " ")))
+	}
+	executeQuery(fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s)", searchTable, strings.Join(definition, ", ")), db)
+	recordArtifact(table.Name, artifactTable, searchTable, db)
+
+	// keep search index in sync with feature table, updates of other columns don't affect the search index
+	newValues := "new." + layerCfg.FidColumn + ", new." + strings.Join(columns, ", new.")
+	oldValues := "old." + layerCfg.FidColumn + ", old." + strings.Join(columns, ", old.")
+	columnList := "rowid, " + strings.Join(columns, ", ")
+	executeQuery(fmt.Sprintf("CREATE TRIGGER %[1]s_ai AFTER INSERT ON %[2]s BEGIN "+
+		"INSERT INTO %[1]s(%[3]s) VALUES (%[4]s); END", searchTable, table.Name, columnList, newValues), db)
+	executeQuery(fmt.Sprintf("CREATE TRIGGER %[1]s_ad AFTER DELETE ON %[2]s BEGIN "+
+		"INSERT INTO %[1]s(%[1]s, %[3]s) VALUES ('delete', %[4]s); END", searchTable, table.Name, columnList, oldValues), db)
+	executeQuery(fmt.Sprintf("CREATE TRIGGER %[1]s_au AFTER UPDATE OF %[6]s ON %[2]s BEGIN "+
+		"INSERT INTO %[1]s(%[1]s, %[3]s) VALUES ('delete', %[4]s); "+
+		"INSERT INTO %[1]s(%[3]s) VALUES (%[5]s); END", searchTable, table.Name, columnList, oldValues, newValues,
+		layerCfg.FidColumn+", "+strings.Join(columns, ", ")), db)
+	for _, suffix := range []string{"_ai", "_ad", "_au"} {
+		recordArtifact(table.Name, artifactTrigger, searchTable+suffix, db)
+	}
+
+	// fill and optimize the search index
+	executeQuery(fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')", searchTable), db)
+	executeQuery(fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('optimize')", searchTable), db)
+
+	report.table(table.Name).Search = &SearchReport{
+		Table:     searchTable,
+		Columns:   search.Columns,
+		Payload:   payload,
+		Tokenizer: search.tokenizer(),
+	}
+}
+
+// fts5Available returns whether SQLite is built with FTS5, which requires building with -tags sqlite_fts5
+func fts5Available(db *sql.DB) bool {
+	var used int
+	err := db.QueryRow("select sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+	if err != nil {
+		log.Fatalf("error reading compile options: %s", err)
+	}
+	return used == 1
+}
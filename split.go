@@ -0,0 +1,360 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ways to split a GeoPackage
+const (
+	splitByTable     = "table"
+	splitByGrid      = "grid"
+	splitByAttribute = "attribute"
+)
+
+// registries referring to tables by name, from which a removed table is removed as well
+var tableRegistries = []string{"gpkg_geometry_columns", "gpkg_extensions", "gpkg_data_columns", "gpkg_tile_matrix",
+	"gpkg_tile_matrix_set", "gpkg_ogr_contents", "gpkg_metadata_reference", artifactsTable, "gpkg_contents"}
+
+// schema name of the source GeoPackage, attached to a part to copy its rows from
+const splitSource = "split_source"
+
+var unsafePartNameCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+type splitOptions struct {
+	by        string
+	gridSize  float64
+	column    string
+	outputDir string
+}
+
+// SplitManifest describes which part of a split GeoPackage holds which collections and extent
+type SplitManifest struct {
+	Source  string      `json:"source"`
+	SplitBy string      `json:"split-by"`
+	Parts   []SplitPart `json:"parts"`
+}
+
+type SplitPart struct {
+	File string `json:"file"`
+	// table name, grid cell (<column>_<row>) or attribute value of the part
+	Key         string            `json:"key"`
+	Extent      []float64         `json:"extent,omitempty"`
+	Collections []SplitCollection `json:"collections"`
+}
+
+type SplitCollection struct {
+	Name         string    `json:"name"`
+	DataType     string    `json:"data-type"`
+	FeatureCount int64     `json:"feature-count"`
+	Extent       []float64 `json:"extent,omitempty"`
+}
+
+// splitGeopackage splits the given GeoPackage in parts: a GeoPackage per table, per grid cell or per attribute value.
+// Every part starts as a copy of an emptied copy of the source (the template), so gpkg_* metadata, optimizer columns,
+// indexes and triggers are preserved, after which the rows of the part are copied from the source and the tables that
+// don't belong to the part are removed. So splitting takes about three times the size of the source in I/O (the
+// template, emptying it and the parts), regardless of the number of parts.
+func splitGeopackage(sourceGeopackage string, options splitOptions) *SplitManifest {
+	log.Printf("Splitting geopackage '%s' by %s...\n", sourceGeopackage, options.by)
+	db := openDb(sourceGeopackage)
+	defer db.Close()
+
+	tables := readTables(db)
+	companions := readCompanionTables(db)
+	keys := splitKeys(tables, companions, options, db)
+	if len(keys) == 0 {
+		log.Fatalf("nothing to split in geopackage '%s'", sourceGeopackage)
+	}
+	err := os.MkdirAll(options.outputDir, 0755)
+	if err != nil {
+		log.Fatalf("cannot create output directory '%s': %s", options.outputDir, err)
+	}
+	template := createSplitTemplate(tables, options.outputDir, db)
+	defer os.Remove(template)
+	templateDb := openDb(template)
+	defer templateDb.Close()
+
+	manifest := &SplitManifest{Source: filepath.Base(sourceGeopackage), SplitBy: options.by}
+	baseName := strings.TrimSuffix(filepath.Base(sourceGeopackage), filepath.Ext(sourceGeopackage))
+	partNames := make(map[string]string)
+	for _, key := range keys {
+		partName := partName(key)
+		if other, ok := partNames[partName]; ok {
+			log.Fatalf("parts '%s' and '%s' would both be written to a file named '%s'", other, key, partName)
+		}
+		partNames[partName] = key
+
+		file := filepath.Join(options.outputDir, fmt.Sprintf("%s_%s.gpkg", baseName, partName))
+		if _, err = os.Stat(file); err == nil {
+			log.Fatalf("part '%s' already exists", file)
+		}
+		log.Printf("Writing part '%s' to '%s'", key, file)
+		executeQuery(fmt.Sprintf("VACUUM INTO '%s'", sqlString(file)), templateDb)
+
+		partDb := openDb(file)
+		// a single connection, since the source is only attached to the connection it is attached on
+		partDb.SetMaxOpenConns(1)
+		executeQuery(fmt.Sprintf("ATTACH DATABASE '%s' AS %s", sqlString(sourceGeopackage), splitSource), partDb)
+		splitTables := fillPart(tables, companions, key, keys[0], options, partDb)
+		executeQuery("DETACH DATABASE "+splitSource, partDb)
+		part := describePart(readTables(partDb), splitTables, partDb)
+		part.File, part.Key = filepath.Base(file), key
+		manifest.Parts = append(manifest.Parts, part)
+		partDb.Close()
+	}
+	return manifest
+}
+
+// createSplitTemplate writes a consistent copy of the source (including changes that are still in the write-ahead log)
+// without the rows of the given tables to a temporary file in the given directory, and returns its path
+func createSplitTemplate(tables []Table, dir string, db *sql.DB) string {
+	file, err := os.CreateTemp(dir, ".split-template-*.gpkg")
+	if err != nil {
+		log.Fatalf("cannot create template for the parts in '%s': %s", dir, err)
+	}
+	file.Close()
+	// VACUUM INTO requires a new or empty file
+	executeQuery(fmt.Sprintf("VACUUM INTO '%s'", sqlString(file.Name())), db)
+
+	templateDb := openDb(file.Name())
+	defer templateDb.Close()
+	// deleted by their triggers, the RTrees, search indexes and feature counts are emptied as well
+	for _, table := range tables {
+		executeQuery(fmt.Sprintf("DELETE FROM \"%s\"", table.Name), templateDb)
+	}
+	executeQuery("VACUUM", templateDb)
+	return file.Name()
+}
+
+// splitKeys returns the keys of the parts: the table names, the grid cells containing features or the distinct values
+// of the attribute column. Companion tables follow their table, so they don't determine keys.
+func splitKeys(tables []Table, companions map[string][]string, options splitOptions, db *sql.DB) []string {
+	parents := companionParents(companions)
+	keys := make(map[string]bool)
+	for _, table := range tables {
+		if _, isCompanion := parents[table.Name]; isCompanion {
+			continue
+		}
+		switch options.by {
+		case splitByTable:
+			keys[table.Name] = true
+		case splitByGrid:
+			if options.gridSize <= 0 {
+				log.Fatalf("grid size must be positive, got %f", options.gridSize)
+			}
+			if !table.IsFeatures || len(table.GeometryColumns) == 0 {
+				continue
+			}
+			for _, cell := range queryStrings(fmt.Sprintf("select distinct %s from \"%s\" where %[1]s is not null",
+				gridCellExpression(table, options.gridSize), table.Name), db) {
+				keys[cell] = true
+			}
+		case splitByAttribute:
+			if options.column == "" {
+				log.Fatalf("column is required to split by attribute")
+			}
+			if !columnExists(table.Name, options.column, db) {
+				continue
+			}
+			for _, value := range queryStrings(fmt.Sprintf("select distinct %s from \"%s\"",
+				attributeExpression(options.column), table.Name), db) {
+				keys[value] = true
+			}
+		default:
+			log.Fatalf("invalid value for split-by: '%s', supported are %s, %s and %s", options.by, splitByTable, splitByGrid, splitByAttribute)
+		}
+	}
+	var result []string
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// fillPart copies the rows of the part with the given key from the attached source to the (empty) tables of the part,
+// removes the tables that don't belong to the part, and returns the names of the tables that are split for the part.
+// Tables that can't be split (e.g. tiles tables when splitting by grid, or tables without the attribute column) are
+// copied as a whole to every part. Companion tables follow their table: they get the rows of the features of their
+// table in the part, and are removed along with their table. Features without geometry are included in the part with
+// the first key when splitting by grid.
+func fillPart(tables []Table, companions map[string][]string, key string, firstKey string, options splitOptions, db *sql.DB) map[string]bool {
+	parents := companionParents(companions)
+	tablesByName := make(map[string]Table)
+	for _, table := range tables {
+		tablesByName[table.Name] = table
+	}
+
+	splitTables := make(map[string]bool)
+	for _, table := range tables {
+		if _, isCompanion := parents[table.Name]; isCompanion {
+			continue
+		}
+		var predicate string
+		switch options.by {
+		case splitByTable:
+			if table.Name != key {
+				removeTable(table, db)
+				continue
+			}
+			splitTables[table.Name] = true
+		case splitByGrid:
+			if !table.IsFeatures || len(table.GeometryColumns) == 0 {
+				log.Printf("WARNING: table '%s' is not split by grid, it's included in every part", table.Name)
+				break
+			}
+			predicate = fmt.Sprintf("coalesce(%s, '%s') = '%s'", gridCellExpression(table, options.gridSize),
+				sqlString(firstKey), sqlString(key))
+			splitTables[table.Name] = true
+		case splitByAttribute:
+			if !columnExists(table.Name, options.column, db) {
+				log.Printf("WARNING: table '%s' has no column '%s', it's included in every part", table.Name, options.column)
+				break
+			}
+			predicate = fmt.Sprintf("%s = '%s'", attributeExpression(options.column), sqlString(key))
+			splitTables[table.Name] = true
+		}
+		copyRows(table.Name, predicate, db)
+		recomputeContents(table, db)
+
+		for _, name := range companions[table.Name] {
+			companion, ok := tablesByName[name]
+			if !ok {
+				continue
+			}
+			fidColumn := detectFidColumn(companion.Name, db)
+			copyRows(companion.Name, fmt.Sprintf("\"%s\" IN (SELECT \"%s\" FROM main.\"%s\")", fidColumn, fidColumn, table.Name), db)
+			recomputeContents(companion, db)
+		}
+	}
+	return splitTables
+}
+
+// copyRows copies the rows of the given table matching the given predicate (every row when empty) from the attached
+// source. The triggers of the table maintain its RTree, search index and feature count.
+func copyRows(tableName string, predicate string, db *sql.DB) {
+	where := ""
+	if predicate != "" {
+		where = " WHERE " + predicate
+	}
+	executeQuery(fmt.Sprintf("INSERT INTO main.\"%[1]s\" SELECT * FROM %[2]s.\"%[1]s\"%[3]s", tableName, splitSource, where), db)
+}
+
+// removeTable drops the given table, its RTree index and everything created for it by the optimizer (e.g. views,
+// search tables and companion tables), and removes it from the gpkg_* registries
+func removeTable(table Table, db *sql.DB) {
+	log.Printf("Removing table '%s'", table.Name)
+	for _, artifact := range readArtifacts(db) {
+		if artifact.TableName != table.Name || artifact.Name == table.Name {
+			continue
+		}
+		switch artifact.Type {
+		case artifactColumn, artifactIndex, artifactTrigger, artifactClustering:
+			// dropped along with the table
+		case artifactGeometryColumn, artifactExtension, artifactContents, artifactTileMatrixSet:
+			// removed from the registries below
+		default:
+			revertArtifact(artifact, db)
+		}
+	}
+	for _, geomColumn := range table.GeometryColumns {
+		executeQuery(fmt.Sprintf("DROP TABLE IF EXISTS \"rtree_%s_%s\"", table.Name, geomColumn.Name), db)
+	}
+	executeQuery(fmt.Sprintf("DROP TABLE IF EXISTS \"%s\"", table.Name), db)
+
+	for _, registry := range tableRegistries {
+		if !tableExists(registry, db) {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf("delete from %s where table_name = ?", registry), table.Name)
+		if err != nil {
+			log.Fatalf("error removing '%s' from %s: %s", table.Name, registry, err)
+		}
+	}
+}
+
+// describePart returns the collections of a part, with their feature count and extent. The extent of the part is the
+// union of the extents of the given split tables, since tables kept as a whole in every part would make every part
+// cover the extent of these tables.
+func describePart(tables []Table, splitTables map[string]bool, db *sql.DB) SplitPart {
+	var part SplitPart
+	for _, table := range tables {
+		collection := SplitCollection{Name: table.Name, DataType: table.DataType}
+		err := db.QueryRow(fmt.Sprintf("select count(*) from \"%s\"", table.Name)).Scan(&collection.FeatureCount)
+		if err != nil {
+			log.Fatalf("error counting rows of table '%s': %s", table.Name, err)
+		}
+		var extent [4]sql.NullFloat64
+		err = db.QueryRow("select min_x, min_y, max_x, max_y from gpkg_contents where table_name = ?", table.Name).
+			Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+		if err != nil {
+			log.Fatalf("error reading extent of table '%s': %s", table.Name, err)
+		}
+		if extent[0].Valid && extent[1].Valid && extent[2].Valid && extent[3].Valid {
+			collection.Extent = []float64{extent[0].Float64, extent[1].Float64, extent[2].Float64, extent[3].Float64}
+			if splitTables[table.Name] {
+				part.Extent = unionExtent(part.Extent, collection.Extent)
+			}
+		}
+		part.Collections = append(part.Collections, collection)
+	}
+	return part
+}
+
+func unionExtent(extent []float64, other []float64) []float64 {
+	if extent == nil {
+		return append([]float64{}, other...)
+	}
+	return []float64{math.Min(extent[0], other[0]), math.Min(extent[1], other[1]), math.Max(extent[2], other[2]), math.Max(extent[3], other[3])}
+}
+
+// gridCellExpression returns the SQL expression of the grid cell containing the center of the bbox of the (primary)
+// geometry of the given table
+func gridCellExpression(table Table, gridSize float64) string {
+	geomColumn := table.GeometryColumns[0].Name
+	return fmt.Sprintf("pdok_grid_cell((ST_MinX(\"%[1]s\") + ST_MaxX(\"%[1]s\")) / 2, (ST_MinY(\"%[1]s\") + ST_MaxY(\"%[1]s\")) / 2, %[2]s)",
+		geomColumn, strconv.FormatFloat(gridSize, 'f', -1, 64))
+}
+
+func attributeExpression(column string) string {
+	return fmt.Sprintf("coalesce(cast(\"%s\" as text), '')", column)
+}
+
+// partName returns the given key as it's used in the file name of a part
+func partName(key string) string {
+	if key == "" {
+		return "none"
+	}
+	return unsafePartNameCharacters.ReplaceAllString(key, "_")
+}
+
+func sqlString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+// sqlGridCell is available in SQL as pdok_grid_cell(x, y, size), returns the cell (<column>_<row>) of a grid with the
+// given cell size and its origin at (0, 0) that contains the given position
+func sqlGridCell(x, y, size any) (any, error) {
+	var values [3]float64
+	for i, value := range []any{x, y, size} {
+		switch v := value.(type) {
+		case float64:
+			values[i] = v
+		case int64:
+			values[i] = float64(v)
+		default:
+			// empty or NULL geometry
+			return nil, nil
+		}
+	}
+	return fmt.Sprintf("%d_%d", int64(math.Floor(values[0]/values[2])), int64(math.Floor(values[1]/values[2]))), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGridCell(t *testing.T) {
+	for _, test := range []struct {
+		x, y     any
+		expected any
+	}{
+		{155000.0, 463000.0, "15_46"},
+		{int64(-5), 9999.99, "-1_0"},
+		{nil, nil, nil},
+	} {
+		cell, err := sqlGridCell(test.x, test.y, int64(10000))
+		if err != nil || cell != test.expected {
+			log.Fatalf("expected cell %v for (%v, %v), got %v (%v)", test.expected, test.x, test.y, cell, err)
+		}
+	}
+	if name := partName("Noord-Brabant / 's-Hertogenbosch"); name != "Noord-Brabant_s-Hertogenbosch" {
+		log.Fatalf("unexpected part name '%s'", name)
+	}
+}
+
+func TestRemoveTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL)", db)
+	executeQuery("CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, "+
+		"geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand', 'features'), ('verblijfsobject', 'features')", db)
+	executeQuery("INSERT INTO gpkg_geometry_columns VALUES ('pand', 'geom', 'POLYGON', 28992, 0, 0), "+
+		"('verblijfsobject', 'geom', 'POINT', 28992, 0, 0)", db)
+	for _, table := range []string{"pand", "verblijfsobject"} {
+		executeQuery("CREATE TABLE "+table+" (fid INTEGER PRIMARY KEY, geom BLOB)", db)
+		executeQuery("CREATE VIEW "+table+"_tiles AS SELECT fid FROM "+table, db)
+		recordArtifact(table, artifactView, table+"_tiles", db)
+		describeColumn(table, "fid", "Fid", "Feature id", "", db)
+	}
+	executeQuery("CREATE TABLE pand_zoom (fid INTEGER PRIMARY KEY, geom BLOB)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand_zoom', 'features')", db)
+	executeQuery("INSERT INTO gpkg_geometry_columns VALUES ('pand_zoom', 'geom', 'POLYGON', 28992, 0, 0)", db)
+	recordArtifact("pand", artifactFeatureTable, "pand_zoom", db)
+	executeQuery("CREATE INDEX pand_geom_idx ON pand (geom)", db)
+	recordArtifact("pand", artifactIndex, "pand_geom_idx", db)
+
+	removeTable(Table{Name: "pand", IsFeatures: true, GeometryColumns: []GeometryColumn{{Name: "geom"}}}, db)
+
+	if tableExists("pand", db) || tableExists("pand_tiles", db) || tableExists("pand_zoom", db) ||
+		!tableExists("verblijfsobject_tiles", db) {
+		log.Fatal("expected table 'pand', its view and companion table to be removed, and nothing else")
+	}
+	for _, registry := range []string{"gpkg_contents", "gpkg_geometry_columns", "gpkg_data_columns", artifactsTable} {
+		var count int
+		err = db.QueryRow("select count(*) from " + registry + " where table_name in ('pand', 'pand_zoom')").Scan(&count)
+		if err != nil || count != 0 {
+			log.Fatalf("expected 'pand' to be removed from %s, got %d rows (%v)", registry, count, err)
+		}
+	}
+	if columns := queryStrings("select column_name from gpkg_data_columns", db); len(columns) != 1 {
+		log.Fatalf("expected the column of 'verblijfsobject' in gpkg_data_columns, got %v", columns)
+	}
+}
+
+func TestFillPartCompanionTables(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("ATTACH DATABASE ':memory:' AS "+splitSource, db)
+	for _, schema := range []string{"main", splitSource} {
+		executeQuery("CREATE TABLE "+schema+".pand (fid INTEGER PRIMARY KEY, provincie TEXT)", db)
+		executeQuery("CREATE TABLE "+schema+".pand_zoom (fid INTEGER PRIMARY KEY NOT NULL, zoom_level INTEGER)", db)
+	}
+	executeQuery("INSERT INTO "+splitSource+".pand VALUES (1, 'Utrecht'), (2, 'Zeeland'), (3, 'Utrecht')", db)
+	executeQuery("INSERT INTO "+splitSource+".pand_zoom VALUES (1, 10), (2, 10), (3, 10)", db)
+	companions := map[string][]string{"pand": {"pand_zoom"}}
+	tables := []Table{{Name: "pand"}, {Name: "pand_zoom"}}
+
+	splitTables := fillPart(tables, companions, "Utrecht", "Utrecht", splitOptions{by: splitByAttribute, column: "provincie"}, db)
+
+	if len(splitTables) != 1 || !splitTables["pand"] {
+		log.Fatalf("expected only 'pand' to be split, got %v", splitTables)
+	}
+	// the companion table has no column 'provincie', its rows follow the features of 'pand' in the part
+	if fids := queryStrings("select fid from pand_zoom order by fid", db); len(fids) != 2 || fids[0] != "1" || fids[1] != "3" {
+		log.Fatalf("expected the companion rows of features 1 and 3, got %v", fids)
+	}
+}
+
+func TestDescribePart(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, "+
+		"min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('pand', 'features', 10, 20, 30, 40), ('gemeente', 'features', 0, 0, 100, 100)", db)
+	for _, table := range []string{"pand", "gemeente"} {
+		executeQuery("CREATE TABLE "+table+" (fid INTEGER PRIMARY KEY)", db)
+	}
+
+	// gemeente is kept as a whole in every part, so it doesn't count for the extent of the part
+	part := describePart([]Table{{Name: "pand", DataType: "features"}, {Name: "gemeente", DataType: "features"}},
+		map[string]bool{"pand": true}, db)
+	if len(part.Extent) != 4 || part.Extent[0] != 10 || part.Extent[1] != 20 || part.Extent[2] != 30 || part.Extent[3] != 40 {
+		log.Fatalf("expected the extent of the split table, got %v", part.Extent)
+	}
+	if len(part.Collections) != 2 || len(part.Collections[1].Extent) != 4 {
+		log.Fatalf("expected both collections with their extent, got %v", part.Collections)
+	}
+}
+
+func TestSplitGeopackageByTable(t *testing.T) {
+	sourceGeopackage := "testdata/geopackage.gpkg"
+	source, err := os.Open("testdata/original_oaf.gpkg")
+	if err != nil {
+		log.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		log.Fatalf("error copying GeoPackage: %s", err)
+	}
+	optimizeOAFGeopackage(sourceGeopackage, "")
+
+	outputDir := t.TempDir()
+	manifest := splitGeopackage(sourceGeopackage, splitOptions{by: splitByTable, outputDir: outputDir})
+	for _, part := range manifest.Parts {
+		if len(part.Collections) != 1 || part.Collections[0].Name != part.Key || part.File != "geopackage_"+part.Key+".gpkg" {
+			log.Fatalf("unexpected part %+v", part)
+		}
+		db := openDb(filepath.Join(outputDir, part.File))
+		tables := readTables(db)
+		if len(tables) != 1 || !columnExists(part.Key, "minx", db) {
+			log.Fatalf("expected only optimized table '%s' in part, got %v", part.Key, tables)
+		}
+		db.Close()
+	}
+}
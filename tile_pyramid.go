@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"sort"
+)
+
+// number of tiles read at once when recompressing, the tiles are updated between batches
+const recompressBatchSize = 1000
+
+var tileCoordinateColumns = []string{"zoom_level", "tile_column", "tile_row"}
+
+// optimizeTilePyramid optimizes a (raster) tiles table: duplicate tiles are removed and the unique index on the tile
+// coordinates is created when missing (unless different tiles share tile coordinates), the zoom levels are checked
+// against gpkg_tile_matrix and optionally the tiles are recompressed
+func optimizeTilePyramid(table Table, pyramid TilePyramid, report *Report, db *sql.DB) {
+	pyramidReport := &TilePyramidReport{}
+	if index, ok := findUniqueIndex(table.Name, tileCoordinateColumns, db); ok {
+		log.Printf("Table '%s' has unique index '%s' on the tile coordinates", table.Name, index)
+		pyramidReport.UniqueIndex = index
+	} else {
+		pyramidReport.DuplicateTiles = removeDuplicateTiles(table.Name, db)
+		pyramidReport.ConflictingTiles = findConflictingTiles(table.Name, db)
+		if len(pyramidReport.ConflictingTiles) > 0 {
+			log.Printf("WARNING: unique index on the tile coordinates of table '%s' is not created, since %d tile coordinates have different tiles",
+				table.Name, len(pyramidReport.ConflictingTiles))
+		} else {
+			pyramidReport.UniqueIndex = fmt.Sprintf("%s_tile_idx", table.Name)
+			createIndex(table.Name, tileCoordinateColumns, pyramidReport.UniqueIndex, true, db)
+		}
+	}
+	checkZoomLevels(table.Name, pyramidReport, db)
+	if pyramid.Recompress != nil {
+		pyramidReport.Recompression = recompressTiles(table.Name, *pyramid.Recompress, db)
+	}
+	report.table(table.Name).TilePyramid = pyramidReport
+}
+
+// findUniqueIndex returns a unique index (or constraint) of the given table on exactly the given columns
+func findUniqueIndex(tableName string, columns []string, db *sql.DB) (string, bool) {
+	expected := append([]string(nil), columns...)
+	sort.Strings(expected)
+	for _, name := range queryStrings("select name from pragma_index_list(?) where \"unique\" = 1", db, tableName) {
+		indexColumns := queryStrings("select coalesce(name, '') from pragma_index_info(?)", db, name)
+		sort.Strings(indexColumns)
+		if fmt.Sprint(indexColumns) == fmt.Sprint(expected) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// removeDuplicateTiles removes all but the most recently inserted tile of every set of identical tiles (same tile
+// coordinates and tile data). Different tiles with the same tile coordinates are left as is, see findConflictingTiles.
+func removeDuplicateTiles(tableName string, db *sql.DB) int64 {
+	query := fmt.Sprintf("DELETE FROM \"%[1]s\" WHERE id NOT IN (SELECT max(id) FROM \"%[1]s\" "+
+		"GROUP BY zoom_level, tile_column, tile_row, tile_data)", tableName)
+	log.Printf("executing query: %s;\n", query)
+	result, err := db.Exec(query)
+	if err != nil {
+		log.Fatalf("error removing duplicate tiles of table '%s': %s", tableName, err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if removed > 0 {
+		log.Printf("Removed %d duplicate tiles from table '%s'", removed, tableName)
+	}
+	return removed
+}
+
+// findConflictingTiles returns the tile coordinates (zoom level, tile column, tile row) with different tiles. It's
+// unknown which tile is correct, so these are only reported.
+func findConflictingTiles(tableName string, db *sql.DB) [][3]int {
+	rows, err := db.Query(fmt.Sprintf("select zoom_level, tile_column, tile_row from \"%s\" group by zoom_level, tile_column, tile_row "+
+		"having count(*) > 1 order by zoom_level, tile_column, tile_row", tableName))
+	if err != nil {
+		log.Fatalf("error reading conflicting tiles of table '%s': %s", tableName, err)
+	}
+	defer rows.Close()
+	var result [][3]int
+	for rows.Next() {
+		var coordinates [3]int
+		err = rows.Scan(&coordinates[0], &coordinates[1], &coordinates[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("WARNING: table '%s' has different tiles at %d/%d/%d", tableName, coordinates[0], coordinates[1], coordinates[2])
+		result = append(result, coordinates)
+	}
+	return result
+}
+
+// checkZoomLevels compares the zoom levels of the tiles with the zoom levels in gpkg_tile_matrix, and counts the tiles
+// outside the matrix of their zoom level
+func checkZoomLevels(tableName string, pyramidReport *TilePyramidReport, db *sql.DB) {
+	pyramidReport.ZoomLevels = queryInts(fmt.Sprintf("select distinct zoom_level from \"%s\" order by zoom_level", tableName), db)
+	if !tableExists("gpkg_tile_matrix", db) {
+		log.Printf("WARNING: gpkg_tile_matrix does not exist, cannot check zoom levels of table '%s'", tableName)
+		return
+	}
+	matrixZoomLevels := queryInts(fmt.Sprintf("select zoom_level from gpkg_tile_matrix where table_name = '%s' order by zoom_level", tableName), db)
+
+	tileZoomLevels := make(map[int]bool)
+	for _, zoom := range pyramidReport.ZoomLevels {
+		tileZoomLevels[zoom] = true
+	}
+	for _, zoom := range matrixZoomLevels {
+		if !tileZoomLevels[zoom] {
+			pyramidReport.MissingZoomLevels = append(pyramidReport.MissingZoomLevels, zoom)
+		}
+		delete(tileZoomLevels, zoom)
+	}
+	for _, zoom := range pyramidReport.ZoomLevels {
+		if tileZoomLevels[zoom] {
+			pyramidReport.UnknownZoomLevels = append(pyramidReport.UnknownZoomLevels, zoom)
+		}
+	}
+	if len(pyramidReport.MissingZoomLevels) > 0 {
+		log.Printf("WARNING: table '%s' has no tiles at zoom level(s) %v of gpkg_tile_matrix", tableName, pyramidReport.MissingZoomLevels)
+	}
+	if len(pyramidReport.UnknownZoomLevels) > 0 {
+		log.Printf("WARNING: table '%s' has tiles at zoom level(s) %v, which are not in gpkg_tile_matrix", tableName, pyramidReport.UnknownZoomLevels)
+	}
+
+	err := db.QueryRow(fmt.Sprintf("select count(*) from \"%s\" t join gpkg_tile_matrix m on m.table_name = ? and m.zoom_level = t.zoom_level "+
+		"where t.tile_column < 0 or t.tile_column >= m.matrix_width or t.tile_row < 0 or t.tile_row >= m.matrix_height", tableName),
+		tableName).Scan(&pyramidReport.OutOfBoundsTiles)
+	if err != nil {
+		log.Fatalf("error reading tiles of table '%s': %s", tableName, err)
+	}
+	if pyramidReport.OutOfBoundsTiles > 0 {
+		log.Printf("WARNING: table '%s' has %d tiles outside the matrix of their zoom level", tableName, pyramidReport.OutOfBoundsTiles)
+	}
+}
+
+// recompressTiles re-encodes the PNG and (when a quality is configured) JPEG tiles, a tile is only replaced when the
+// result is smaller. Other formats (e.g. WebP) are left untouched, since no encoder is available.
+func recompressTiles(tableName string, recompress Recompress, db *sql.DB) *RecompressionReport {
+	log.Printf("Recompressing tiles of table '%s'", tableName)
+	recompressionReport := &RecompressionReport{}
+	var lastID int64
+	for {
+		// a batch is read completely before updating, so a single connection suffices (e.g. in a dry run)
+		rows, err := db.Query(fmt.Sprintf("select id, tile_data from \"%s\" where id > ? order by id limit %d",
+			tableName, recompressBatchSize), lastID)
+		if err != nil {
+			log.Fatalf("error reading tiles of table '%s': %s", tableName, err)
+		}
+		recompressed := make(map[int64][]byte)
+		count := 0
+		for rows.Next() {
+			var id int64
+			var data []byte
+			err = rows.Scan(&id, &data)
+			if err != nil {
+				log.Fatal(err)
+			}
+			count++
+			lastID = id
+			recompressionReport.BytesBefore += int64(len(data))
+			result, ok := recompressTile(id, data, recompress)
+			if ok && len(result) < len(data) {
+				recompressed[id] = result
+				data = result
+			}
+			if !ok {
+				recompressionReport.Skipped++
+			}
+			recompressionReport.BytesAfter += int64(len(data))
+		}
+		rows.Close()
+
+		for id, data := range recompressed {
+			_, err = db.Exec(fmt.Sprintf("update \"%s\" set tile_data = ? where id = ?", tableName), data, id)
+			if err != nil {
+				log.Fatalf("error updating tile %d of table '%s': %s", id, tableName, err)
+			}
+		}
+		recompressionReport.Tiles += int64(len(recompressed))
+		if count < recompressBatchSize {
+			break
+		}
+	}
+	log.Printf("Recompressed %d tiles of table '%s' from %d to %d bytes, skipped %d tiles", recompressionReport.Tiles,
+		tableName, recompressionReport.BytesBefore, recompressionReport.BytesAfter, recompressionReport.Skipped)
+	return recompressionReport
+}
+
+// recompressTile re-encodes a single tile, it returns false when the tile can't be recompressed
+func recompressTile(id int64, data []byte, recompress Recompress) ([]byte, bool) {
+	var buffer bytes.Buffer
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("WARNING: cannot decode PNG tile %d: %s", id, err)
+			return nil, false
+		}
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buffer, toPaletted(img))
+		if err != nil {
+			log.Fatalf("error encoding PNG tile %d: %s", id, err)
+		}
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}) && recompress.JPEGQuality > 0:
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("WARNING: cannot decode JPEG tile %d: %s", id, err)
+			return nil, false
+		}
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: recompress.JPEGQuality})
+		if err != nil {
+			log.Fatalf("error encoding JPEG tile %d: %s", id, err)
+		}
+	default:
+		return nil, false
+	}
+	return buffer.Bytes(), true
+}
+
+// toPaletted returns the given image as paletted image when it has at most 256 colors, which is encoded as a
+// (much) smaller PNG without loss. Images with 16 bits per channel are returned as is.
+func toPaletted(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.Paletted, *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return img
+	}
+	bounds := img.Bounds()
+	var palette color.Palette
+	indexes := make(map[color.NRGBA]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if _, ok := indexes[c]; ok {
+				continue
+			}
+			if len(palette) == 256 {
+				return img
+			}
+			indexes[c] = uint8(len(palette))
+			palette = append(palette, c)
+		}
+	}
+
+	paletted := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			paletted.SetColorIndex(x, y, indexes[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)])
+		}
+	}
+	return paletted
+}
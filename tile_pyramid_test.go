@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"testing"
+)
+
+func TestOptimizeTilePyramid(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_tile_matrix (table_name TEXT NOT NULL, zoom_level INTEGER NOT NULL, "+
+		"matrix_width INTEGER NOT NULL, matrix_height INTEGER NOT NULL)", db)
+	executeQuery("INSERT INTO gpkg_tile_matrix VALUES ('luchtfoto', 0, 1, 1), ('luchtfoto', 1, 2, 2), ('luchtfoto', 2, 4, 4)", db)
+	// without the unique constraint on the tile coordinates
+	executeQuery("CREATE TABLE luchtfoto (id INTEGER PRIMARY KEY AUTOINCREMENT, zoom_level INTEGER NOT NULL, "+
+		"tile_column INTEGER NOT NULL, tile_row INTEGER NOT NULL, tile_data BLOB NOT NULL)", db)
+	tile := uniformPNG(color.NRGBA{R: 0, G: 128, B: 255, A: 255})
+	// a duplicate tile at zoom 0, a tile outside the matrix at zoom 1, no tiles at zoom 2 and a tile at unknown zoom 3
+	for _, coordinates := range [][3]int{{0, 0, 0}, {0, 0, 0}, {1, 2, 0}, {3, 0, 0}} {
+		_, err = db.Exec("insert into luchtfoto (zoom_level, tile_column, tile_row, tile_data) values (?, ?, ?, ?)",
+			coordinates[0], coordinates[1], coordinates[2], tile)
+		if err != nil {
+			log.Fatalf("error inserting tile: %s", err)
+		}
+	}
+
+	report := newReport("test.gpkg", "tiles")
+	optimizeTilePyramid(Table{Name: "luchtfoto", DataType: "tiles"}, TilePyramid{Recompress: &Recompress{}}, report, db)
+
+	pyramidReport := report.table("luchtfoto").TilePyramid
+	if pyramidReport.UniqueIndex != "luchtfoto_tile_idx" || pyramidReport.DuplicateTiles != 1 || pyramidReport.OutOfBoundsTiles != 1 {
+		log.Fatalf("unexpected tile pyramid report %+v", pyramidReport)
+	}
+	if fmt.Sprint(pyramidReport.ZoomLevels) != "[0 1 3]" || fmt.Sprint(pyramidReport.MissingZoomLevels) != "[2]" ||
+		fmt.Sprint(pyramidReport.UnknownZoomLevels) != "[3]" {
+		log.Fatalf("unexpected zoom levels in tile pyramid report %+v", pyramidReport)
+	}
+	recompression := pyramidReport.Recompression
+	if recompression.Tiles != 3 || recompression.Skipped != 0 || recompression.BytesAfter >= recompression.BytesBefore {
+		log.Fatalf("unexpected recompression report %+v", recompression)
+	}
+	if _, ok := findUniqueIndex("luchtfoto", []string{"tile_row", "tile_column", "zoom_level"}, db); !ok {
+		log.Fatalf("expected unique index on the tile coordinates")
+	}
+
+	// the recompressed tile is a paletted PNG with the same pixels
+	var data []byte
+	err = db.QueryRow("select tile_data from luchtfoto where zoom_level = 0").Scan(&data)
+	if err != nil {
+		log.Fatalf("error reading tile: %s", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("error decoding recompressed tile: %s", err)
+	}
+	if _, ok := img.(*image.Paletted); !ok || color.NRGBAModel.Convert(img.At(10, 10)) != (color.NRGBA{R: 0, G: 128, B: 255, A: 255}) {
+		log.Fatalf("unexpected recompressed tile %T %v", img, img.At(10, 10))
+	}
+}
+
+// uniformPNG returns a tile of a single color, encoded as (non paletted) PNG without compression
+func uniformPNG(c color.NRGBA) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buffer bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	err := encoder.Encode(&buffer, img)
+	if err != nil {
+		log.Fatalf("error encoding tile: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestOptimizeTilePyramidConflictingTiles(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE luchtfoto (id INTEGER PRIMARY KEY AUTOINCREMENT, zoom_level INTEGER NOT NULL, "+
+		"tile_column INTEGER NOT NULL, tile_row INTEGER NOT NULL, tile_data BLOB NOT NULL)", db)
+	// an identical duplicate at zoom 0 and different tiles at zoom 1
+	for _, tile := range []struct {
+		coordinates [3]int
+		color       color.NRGBA
+	}{
+		{[3]int{0, 0, 0}, color.NRGBA{R: 255, A: 255}},
+		{[3]int{0, 0, 0}, color.NRGBA{R: 255, A: 255}},
+		{[3]int{1, 1, 0}, color.NRGBA{G: 255, A: 255}},
+		{[3]int{1, 1, 0}, color.NRGBA{B: 255, A: 255}},
+	} {
+		_, err = db.Exec("insert into luchtfoto (zoom_level, tile_column, tile_row, tile_data) values (?, ?, ?, ?)",
+			tile.coordinates[0], tile.coordinates[1], tile.coordinates[2], uniformPNG(tile.color))
+		if err != nil {
+			log.Fatalf("error inserting tile: %s", err)
+		}
+	}
+
+	report := newReport("test.gpkg", "tiles")
+	optimizeTilePyramid(Table{Name: "luchtfoto", DataType: "tiles"}, TilePyramid{}, report, db)
+
+	pyramidReport := report.table("luchtfoto").TilePyramid
+	if pyramidReport.UniqueIndex != "" || pyramidReport.DuplicateTiles != 1 || fmt.Sprint(pyramidReport.ConflictingTiles) != "[[1 1 0]]" {
+		log.Fatalf("unexpected tile pyramid report %+v", pyramidReport)
+	}
+	var tiles int
+	err = db.QueryRow("select count(*) from luchtfoto where zoom_level = 1").Scan(&tiles)
+	if err != nil || tiles != 2 {
+		log.Fatalf("expected both conflicting tiles to be kept, got %d (%v)", tiles, err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tile size in pixels, for the resolution of a zoom level
+const tileSize = 256
+
+// TileMatrixSet is a quadtree tiling scheme of the OGC Two Dimensional Tile Matrix Set standard
+type TileMatrixSet struct {
+	ID  string
+	CRS int64
+	// top left corner of the tile matrix set
+	OriginX float64
+	OriginY float64
+	// width and height of the single tile at zoom level 0
+	Span float64
+}
+
+var tileMatrixSets = map[string]TileMatrixSet{
+	"NetherlandsRDNewQuad": {"NetherlandsRDNewQuad", epsgRDNew, -285401.92, 903401.92, 3440.640 * tileSize},
+	"WebMercatorQuad":      {"WebMercatorQuad", epsgWebMercator, -20037508.3427892, 20037508.3427892, 2 * 20037508.3427892},
+}
+
+// tileSpan returns the width and height of a tile at the given zoom level
+func (t TileMatrixSet) tileSpan(zoom int) float64 {
+	return t.Span / math.Exp2(float64(zoom))
+}
+
+// resolution returns the size of a pixel at the given zoom level
+func (t TileMatrixSet) resolution(zoom int) float64 {
+	return t.tileSpan(zoom) / tileSize
+}
+
+// bounds returns the extent (minx, miny, maxx, maxy) of the tile matrix set
+func (t TileMatrixSet) bounds() [4]float64 {
+	return [4]float64{t.OriginX, t.OriginY - t.Span, t.OriginX + t.Span, t.OriginY}
+}
+
+// columnPrefix returns the prefix of the tile coordinate columns of the tile matrix set
+func (t TileMatrixSet) columnPrefix() string {
+	return strings.ToLower(t.ID) + "_"
+}
+
+func findTileMatrixSet(id string) TileMatrixSet {
+	tileMatrixSet, ok := tileMatrixSets[id]
+	if !ok {
+		log.Fatalf("unknown tile matrix set '%s', supported are NetherlandsRDNewQuad and WebMercatorQuad", id)
+	}
+	return tileMatrixSet
+}
+
+func optimizeTiles(config string, report *Report, db *sql.DB) {
+	tables := readTables(db)
+
+	var tilesConfig TilesConfig
+	if config != "" {
+		tilesConfig = parseTilesConfig(config)
+	}
+	var vectorTilesLayers []vectorTilesLayer
+	for _, table := range tables {
+		if table.DataType == "tiles" {
+			pyramid := TilePyramid{}
+			if config != "" {
+				var ok bool
+				if pyramid, ok = tilesConfig.getPyramid(table.Name); !ok {
+					continue
+				}
+			}
+			optimizeTilePyramid(table, pyramid, report, db)
+			continue
+		}
+		if !table.IsFeatures {
+			log.Printf("Skipping table '%s' because it is not of type 'features' or 'tiles'", table.Name)
+			continue
+		}
+		tilesLayer := defaultTilesLayer()
+		if config != "" {
+			var ok bool
+			if tilesLayer, ok = tilesConfig.getLayer(table.Name); !ok {
+				continue
+			}
+		}
+		if tilesLayer.MinZoom < 0 || tilesLayer.MaxZoom < tilesLayer.MinZoom {
+			log.Fatalf("invalid zoom levels %d-%d for table '%s'", tilesLayer.MinZoom, tilesLayer.MaxZoom, table.Name)
+		}
+
+		layerCfg := resolveLayer(table, Layer{FidColumn: tilesLayer.FidColumn, GeomColumn: tilesLayer.GeomColumn}, db)
+		report.table(table.Name).layer(table, layerCfg)
+		addOAFDefaultOptimizations(table, layerCfg.FidColumn, layerCfg.GeomColumns[:1], nil, db)
+
+		tilesReport := &TilesReport{MinZoom: tilesLayer.MinZoom, MaxZoom: tilesLayer.MaxZoom}
+		for _, id := range tilesLayer.TileMatrixSets {
+			tilesReport.TileMatrixSets = append(tilesReport.TileMatrixSets,
+				addTileCoordinateColumns(table, layerCfg, findTileMatrixSet(id), tilesLayer.MaxZoom, db))
+		}
+		tilesReport.Zooms = addZoomGeometries(table, layerCfg, tilesLayer, db)
+		tilesReport.Attributes = tilesLayer.Attributes
+		tilesReport.View = createTilesView(table, layerCfg, tilesReport, db)
+		report.table(table.Name).Tiles = tilesReport
+
+		source, _ := table.geometryColumn(layerCfg.GeomColumn)
+		// simplified geometries are only used for vector tiles of the tile matrix set they are simplified for
+		zoomTables := make(map[int]string)
+		for _, zoom := range tilesReport.Zooms {
+			if tilesConfig.VectorTiles != nil && zoom.TileMatrixSet == tilesConfig.VectorTiles.TileMatrixSet {
+				zoomTables[zoom.Zoom] = zoom.Table
+			}
+		}
+		vectorTilesLayers = append(vectorTilesLayers, vectorTilesLayer{
+			table:      table,
+			fidColumn:  layerCfg.FidColumn,
+			source:     source,
+			attributes: tilesLayer.Attributes,
+			minZoom:    tilesLayer.MinZoom,
+			zoomTables: zoomTables,
+		})
+	}
+
+	// bring metadata in line with the optimized tables
+	for _, table := range tables {
+		updateExtent(table, report, db)
+		updateFeatureCount(table, report, db)
+	}
+
+	if tilesConfig.VectorTiles != nil {
+		generateVectorTiles(*tilesConfig.VectorTiles, vectorTilesLayers, report, db)
+	}
+
+	// finally, optimize db by gathering statistics
+	analyze(db)
+}
+
+// addTileCoordinateColumns adds the range of tiles (at the max zoom level) covered by the bbox of every feature, and
+// an index on these columns. Since the tile matrix sets are quadtrees, the tiles at a lower zoom level z are found by
+// shifting the tile coordinates by (max zoom - z) bits, e.g. a feature intersects tile z/x/y when
+// min_x >> (max zoom - z) <= x <= max_x >> (max zoom - z), likewise for y.
+func addTileCoordinateColumns(table Table, layerCfg Layer, tileMatrixSet TileMatrixSet, maxZoom int, db *sql.DB) TileCoordinatesReport {
+	source, ok := table.geometryColumn(layerCfg.GeomColumn)
+	if !ok {
+		log.Fatalf("cannot determine CRS of geometry column '%s' of table '%s'", layerCfg.GeomColumn, table.Name)
+	}
+	bbox := make(map[string]string)
+	for _, component := range []string{"minx", "maxx", "miny", "maxy"} {
+		switch {
+		case source.SrsID == tileMatrixSet.CRS:
+			bbox[component] = component
+		default:
+			bbox[component] = transformBBoxExpression(source.Name, source.SrsID, tileMatrixSet.CRS, component)
+		}
+	}
+
+	// cast truncates towards zero, which equals floor for coordinates inside the tile matrix set,
+	// coordinates outside the tile matrix set are clamped to the outermost tiles
+	size := strconv.FormatFloat(tileMatrixSet.tileSpan(maxZoom), 'f', -1, 64)
+	maxTile := (1 << maxZoom) - 1
+	tileX := func(x string) string {
+		return fmt.Sprintf("max(0, min(%d, cast((%s - (%s)) / %s as integer)))", maxTile, x,
+			strconv.FormatFloat(tileMatrixSet.OriginX, 'f', -1, 64), size)
+	}
+	tileY := func(y string) string {
+		return fmt.Sprintf("max(0, min(%d, cast((%s - %s) / %s as integer)))", maxTile,
+			strconv.FormatFloat(tileMatrixSet.OriginY, 'f', -1, 64), y, size)
+	}
+
+	prefix := tileMatrixSet.columnPrefix()
+	columns := map[string]string{
+		"tile_min_x": tileX(bbox["minx"]),
+		"tile_max_x": tileX(bbox["maxx"]),
+		"tile_min_y": tileY(bbox["maxy"]),
+		"tile_max_y": tileY(bbox["miny"]),
+	}
+	var indexColumns []string
+	for _, column := range []string{"tile_min_x", "tile_max_x", "tile_min_y", "tile_max_y"} {
+		addColumn(table.Name, prefix+column, "INTEGER", db)
+		setColumnValue(table.Name, prefix+column, columns[column], db)
+		describeColumn(table.Name, prefix+column, fmt.Sprintf("%s %s", tileMatrixSet.ID, strings.ReplaceAll(column, "_", " ")),
+			fmt.Sprintf("%s of the tiles covered by the bbox of the feature in tile matrix set %s at zoom level %d",
+				strings.ReplaceAll(column, "_", " "), tileMatrixSet.ID, maxZoom), "", db)
+		indexColumns = append(indexColumns, prefix+column)
+	}
+	indexName := fmt.Sprintf("%s_%stile_idx", table.Name, prefix)
+	createIndex(table.Name, indexColumns, indexName, false, db)
+
+	return TileCoordinatesReport{
+		TileMatrixSet: tileMatrixSet.ID,
+		Zoom:          maxZoom,
+		Prefix:        prefix,
+		Index:         indexName,
+	}
+}
+
+// addZoomGeometries adds a geometry simplified with a tolerance of one pixel for zoom levels below the max zoom level,
+// stored in a companion table <table>_<geometry column>_z<zoom> per zoom level. At the max zoom level the geometry
+// itself is used. Without configured simplified zoom levels, the companion table of a zoom level is only kept when the
+// tolerance reduces the number of points compared to the next finer zoom level (e.g. never for points), otherwise it
+// is dropped again and the geometries of the next finer zoom level are used at this zoom level as well.
+func addZoomGeometries(table Table, layerCfg Layer, tilesLayer TilesLayer, db *sql.DB) []ZoomGeometryReport {
+	source, ok := table.geometryColumn(layerCfg.GeomColumn)
+	if !ok {
+		log.Fatalf("cannot determine type of geometry column '%s' of table '%s'", layerCfg.GeomColumn, table.Name)
+	}
+	// the tolerance is in units of the geometry column, so prefer a tile matrix set in the same CRS
+	tileMatrixSet := findTileMatrixSet(tilesLayer.TileMatrixSets[0])
+	for _, id := range tilesLayer.TileMatrixSets {
+		if findTileMatrixSet(id).CRS == source.SrsID {
+			tileMatrixSet = findTileMatrixSet(id)
+			break
+		}
+	}
+	if tileMatrixSet.CRS != source.SrsID {
+		log.Printf("WARNING: no tile matrix set in the CRS of table '%s', simplification tolerances are based on %s",
+			table.Name, tileMatrixSet.ID)
+	}
+	for _, zoom := range tilesLayer.SimplifiedZoomLevels {
+		if zoom < tilesLayer.MinZoom || zoom >= tilesLayer.MaxZoom {
+			log.Fatalf("simplified zoom level %d of table '%s' is not below max zoom level %d and at least min zoom level %d",
+				zoom, table.Name, tilesLayer.MaxZoom, tilesLayer.MinZoom)
+		}
+	}
+
+	simplified := func(tolerance string) string {
+		return fmt.Sprintf("ST_SimplifyPreserveTopology(GeomFromGPB(\"%s\"), %s)", source.Name, tolerance)
+	}
+	finerPoints := countPoints(table, fmt.Sprintf("GeomFromGPB(\"%s\")", source.Name), db)
+	var result []ZoomGeometryReport
+	// from fine to coarse, so every zoom level is compared with the next finer zoom level
+	for zoom := tilesLayer.MaxZoom - 1; zoom >= tilesLayer.MinZoom; zoom-- {
+		tolerance := strconv.FormatFloat(tileMatrixSet.resolution(zoom), 'f', -1, 64)
+		configured := len(tilesLayer.SimplifiedZoomLevels) > 0
+		if configured && !slices.Contains(tilesLayer.SimplifiedZoomLevels, zoom) {
+			continue
+		}
+
+		lastArtifactID := readLastArtifactID(db)
+		companion := createCompanionTable(table, layerCfg.FidColumn, fmt.Sprintf("%s_%s_z%d", table.Name, source.Name, zoom), source,
+			fmt.Sprintf("AsGPB(%s)", simplified(tolerance)),
+			fmt.Sprintf("Geometry column '%s' of table '%s' simplified with a tolerance of one pixel at zoom level %d of %s",
+				source.Name, table.Name, zoom, tileMatrixSet.ID), db)
+		if !configured {
+			points := countPoints(companion, fmt.Sprintf("GeomFromGPB(\"%s\")", source.Name), db)
+			if points >= finerPoints {
+				log.Printf("Dropping simplified geometries of table '%s' at zoom level %d, since these have as many points as the next finer zoom level",
+					table.Name, zoom)
+				revertArtifactsSince(lastArtifactID, db)
+				continue
+			}
+			finerPoints = points
+		}
+		result = append([]ZoomGeometryReport{{
+			TileMatrixSet: tileMatrixSet.ID,
+			Zoom:          zoom,
+			Table:         companion.Name,
+			Column:        source.Name,
+			Tolerance:     tileMatrixSet.resolution(zoom),
+		}}, result...)
+	}
+	return result
+}
+
+// countPoints returns the total number of points of the given geometry expression over all features of the given table
+func countPoints(table Table, geometry string, db *sql.DB) int64 {
+	var points sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("select sum(ST_NPoints(%s)) from \"%s\"", geometry, table.Name)).Scan(&points)
+	if err != nil {
+		log.Fatalf("error counting points of table '%s': %s", table.Name, err)
+	}
+	return points.Int64
+}
+
+// createTilesView creates a view <table>_tiles, exposing only what goes into the tiles: the fid, the allowed
+// attributes, the geometry, the simplified geometries of the companion tables (as <geometry column>_z<zoom>) and the
+// tile coordinates
+func createTilesView(table Table, layerCfg Layer, tilesReport *TilesReport, db *sql.DB) string {
+	columns := readColumns(table.Name, db)
+	selection := []string{layerCfg.FidColumn}
+	for _, attribute := range tilesReport.Attributes {
+		if _, ok := findColumn(columns, attribute); !ok {
+			log.Fatalf("attribute '%s' does not exist in table '%s'", attribute, table.Name)
+		}
+		selection = append(selection, attribute)
+	}
+	selection = append(selection, layerCfg.GeomColumn)
+	for i := range selection {
+		selection[i] = fmt.Sprintf("t.\"%s\"", selection[i])
+	}
+	var joins []string
+	for _, zoom := range tilesReport.Zooms {
+		alias := fmt.Sprintf("z%d", zoom.Zoom)
+		selection = append(selection, fmt.Sprintf("%s.\"%s\" AS \"%s_%s\"", alias, zoom.Column, zoom.Column, alias))
+		joins = append(joins, fmt.Sprintf(" LEFT JOIN \"%s\" %s ON %[2]s.\"%[3]s\" = t.\"%[3]s\"", zoom.Table, alias, layerCfg.FidColumn))
+	}
+	for _, tileCoordinates := range tilesReport.TileMatrixSets {
+		for _, column := range []string{"tile_min_x", "tile_max_x", "tile_min_y", "tile_max_y"} {
+			selection = append(selection, fmt.Sprintf("t.\"%s\"", tileCoordinates.Prefix+column))
+		}
+	}
+
+	viewName := table.Name + "_tiles"
+	executeQuery(fmt.Sprintf("CREATE VIEW \"%s\" AS SELECT %s FROM \"%s\" t%s", viewName,
+		strings.Join(selection, ", "), table.Name, strings.Join(joins, "")), db)
+	recordArtifact(table.Name, artifactView, viewName, db)
+	return viewName
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/creasty/defaults"
+)
+
+type TilesConfig struct {
+	Layers      map[string]TilesLayer `json:"layers"`
+	VectorTiles *VectorTiles          `json:"vector-tiles"`
+	// Pyramids configures the optimization of (raster) tiles tables
+	Pyramids map[string]TilePyramid `json:"pyramids"`
+}
+
+// parseTilesConfig unmarshals the given JSON config and applies the defaults
+func parseTilesConfig(config string) TilesConfig {
+	var tilesConfig TilesConfig
+	err := json.Unmarshal([]byte(config), &tilesConfig)
+	if err != nil {
+		log.Fatalf("cannot unmarshal tiles config: %s", err)
+	}
+	err = defaults.Set(&tilesConfig)
+	if err != nil {
+		log.Fatalf("failed to set default config: %s", err)
+	}
+	return tilesConfig
+}
+
+func (t TilesConfig) getLayer(tableName string) (TilesLayer, bool) {
+	if _, ok := t.Layers[tableName]; !ok {
+		log.Printf("WARNING: no config found for gpkg table '%s'", tableName)
+		return TilesLayer{}, false
+	}
+	return t.Layers[tableName], true
+}
+
+func (t TilesConfig) getPyramid(tableName string) (TilePyramid, bool) {
+	if _, ok := t.Pyramids[tableName]; !ok {
+		log.Printf("WARNING: no config found for gpkg tiles table '%s'", tableName)
+		return TilePyramid{}, false
+	}
+	return t.Pyramids[tableName], true
+}
+
+type TilesLayer struct {
+	FidColumn      string   `json:"fid-column"`
+	GeomColumn     string   `json:"geom-column"`
+	TileMatrixSets []string `json:"tile-matrix-sets" default:"[\"NetherlandsRDNewQuad\"]"`
+	MinZoom        int      `json:"min-zoom"`
+	MaxZoom        int      `json:"max-zoom" default:"12"`
+	// SimplifiedZoomLevels are the zoom levels with simplified geometries, by default every zoom level below the max
+	// zoom level at which simplification reduces the number of points
+	SimplifiedZoomLevels []int `json:"simplified-zoom-levels"`
+	// Attributes is the allow-list of columns included in the tiles, by default no attributes are included
+	Attributes []string `json:"attributes"`
+}
+
+// defaultTilesLayer returns the layer config used for every feature table when no config is given
+func defaultTilesLayer() TilesLayer {
+	var layer TilesLayer
+	err := defaults.Set(&layer)
+	if err != nil {
+		log.Fatalf("failed to set default config: %s", err)
+	}
+	return layer
+}
+
+// VectorTiles configures the generation of Mapbox Vector Tiles of all prepared layers into a GeoPackage tiles table
+type VectorTiles struct {
+	TableName     string `json:"table-name" default:"vector_tiles"`
+	TileMatrixSet string `json:"tile-matrix-set" default:"NetherlandsRDNewQuad"`
+	MinZoom       int    `json:"min-zoom"`
+	MaxZoom       int    `json:"max-zoom" default:"12"`
+	// Extent is the size of a tile in tile coordinates
+	Extent uint32 `json:"extent" default:"4096"`
+	// Buffer around a tile in tile coordinates, in which geometries are included to prevent rendering artifacts
+	Buffer uint32 `json:"buffer" default:"64"`
+}
+
+type TilePyramid struct {
+	// Recompress re-encodes the tiles, by default the tiles are left as is
+	Recompress *Recompress `json:"recompress"`
+}
+
+type Recompress struct {
+	// JPEGQuality (1-100) of recompressed JPEG tiles, JPEG tiles are only recompressed when set since this is lossy
+	JPEGQuality int `json:"jpeg-quality"`
+}
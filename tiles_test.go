@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestAddTileCoordinateColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE pand (fid INTEGER PRIMARY KEY, minx numeric, maxx numeric, miny numeric, maxy numeric)", db)
+	// a feature in Amersfoort, and a feature partly outside the tile matrix set
+	executeQuery("INSERT INTO pand VALUES (1, 155000, 155300, 463000, 463100), (2, -300000, -285000, 900000, 910000)", db)
+
+	table := Table{Name: "pand", IsFeatures: true, GeometryColumns: []GeometryColumn{{Name: "geom", GeometryType: "POLYGON", SrsID: epsgRDNew}}}
+	layerCfg := Layer{FidColumn: "fid", GeomColumn: "geom"}
+	tileCoordinates := addTileCoordinateColumns(table, layerCfg, findTileMatrixSet("NetherlandsRDNewQuad"), 12, db)
+	if tileCoordinates.Prefix != "netherlandsrdnewquad_" || tileCoordinates.Index != "pand_netherlandsrdnewquad_tile_idx" {
+		log.Fatalf("unexpected tile coordinates report %v", tileCoordinates)
+	}
+
+	// tiles at zoom 12 are 215.04 m wide, starting at (-285401.92, 903401.92)
+	expected := map[int64][4]int64{
+		1: {2048, 2049, 2047, 2048},
+		2: {0, 1, 0, 15},
+	}
+	for fid, tiles := range expected {
+		var actual [4]int64
+		err = db.QueryRow("select netherlandsrdnewquad_tile_min_x, netherlandsrdnewquad_tile_max_x, "+
+			"netherlandsrdnewquad_tile_min_y, netherlandsrdnewquad_tile_max_y from pand where fid = ?", fid).
+			Scan(&actual[0], &actual[1], &actual[2], &actual[3])
+		if err != nil || actual != tiles {
+			log.Fatalf("expected tiles %v for feature %d, got %v (%v)", tiles, fid, actual, err)
+		}
+	}
+}
+
+func TestTileMatrixSetResolution(t *testing.T) {
+	if resolution := findTileMatrixSet("NetherlandsRDNewQuad").resolution(12); resolution != 0.84 {
+		log.Fatalf("expected resolution 0.84 at zoom 12 of NetherlandsRDNewQuad, got %f", resolution)
+	}
+	if resolution := findTileMatrixSet("WebMercatorQuad").resolution(0); resolution < 156543.03 || resolution > 156543.04 {
+		log.Fatalf("expected resolution 156543.03 at zoom 0 of WebMercatorQuad, got %f", resolution)
+	}
+}
@@ -18,10 +18,24 @@ func registerDriver(driverName string, extensions []string) {
 		}
 	}
 	sql.Register(driverName, &sqlite3.SQLiteDriver{
-		Extensions: extensions,
+		Extensions:  extensions,
+		ConnectHook: registerFunctions,
 	})
 }
 
+// registerFunctions makes the SQL functions implemented in Go available on the given connection
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	err := conn.RegisterFunc("pdok_transform_bbox", sqlTransformBBox, true)
+	if err != nil {
+		return err
+	}
+	err = conn.RegisterFunc("pdok_curve_key", sqlCurveKey, true)
+	if err != nil {
+		return err
+	}
+	return conn.RegisterFunc("pdok_grid_cell", sqlGridCell, true)
+}
+
 func openDb(sourceGeopackage string) *sql.DB {
 	registerDriver(
 		"sqlite3_with_extensions",
@@ -39,8 +53,28 @@ func openDb(sourceGeopackage string) *sql.DB {
 }
 
 type Table struct {
-	Name       string
-	IsFeatures bool
+	Name            string
+	DataType        string
+	IsFeatures      bool
+	GeometryColumns []GeometryColumn
+}
+
+type GeometryColumn struct {
+	Name         string `json:"name"`
+	GeometryType string `json:"geometry-type"`
+	SrsID        int64  `json:"srs-id"`
+	Z            int    `json:"z"`
+	M            int    `json:"m"`
+}
+
+// geometryColumn returns the registered geometry column with the given name
+func (t Table) geometryColumn(name string) (GeometryColumn, bool) {
+	for _, column := range t.GeometryColumns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return GeometryColumn{}, false
 }
 
 func readTables(db *sql.DB) []Table {
@@ -58,9 +92,50 @@ func readTables(db *sql.DB) []Table {
 		}
 		result = append(result, Table{
 			Name:       tableName,
+			DataType:   dataType,
 			IsFeatures: dataType == "features",
 		})
 	}
+	rows.Close()
+
+	geometryColumns := readGeometryColumns(db)
+	for i := range result {
+		result[i].GeometryColumns = geometryColumns[result[i].Name]
+	}
+	return result
+}
+
+func findTable(tables []Table, name string) (Table, bool) {
+	for _, table := range tables {
+		if table.Name == name {
+			return table, true
+		}
+	}
+	return Table{}, false
+}
+
+// readGeometryColumns returns the geometry columns registered in gpkg_geometry_columns, per table. GeoPackages
+// containing only attribute tables may lack gpkg_geometry_columns.
+func readGeometryColumns(db *sql.DB) map[string][]GeometryColumn {
+	result := make(map[string][]GeometryColumn)
+	if !tableExists("gpkg_geometry_columns", db) {
+		return result
+	}
+	rows, err := db.Query("select table_name, column_name, geometry_type_name, srs_id, z, m from gpkg_geometry_columns")
+	if err != nil {
+		log.Fatalf("error selecting gpkg_geometry_columns: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tableName string
+		var column GeometryColumn
+		err = rows.Scan(&tableName, &column.Name, &column.GeometryType, &column.SrsID, &column.Z, &column.M)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result[tableName] = append(result[tableName], column)
+	}
 	return result
 }
 
@@ -83,6 +158,7 @@ func createIndex(tableName string, columnNames []string, indexName string, uniqu
 	if err != nil {
 		log.Fatalf("error creating index: %s", err)
 	}
+	recordArtifact(tableName, artifactIndex, indexName, db)
 }
 
 func setColumnValue(tableName string, columnName string, value string, db *sql.DB) {
@@ -103,6 +179,7 @@ func addColumn(tableName string, columnName string, columnType string, db *sql.D
 	if err != nil {
 		log.Fatalf("error adding column '%s': '%s'", columnName, err)
 	}
+	recordArtifact(tableName, artifactColumn, columnName, db)
 }
 
 func executeQuery(query string, db *sql.DB) {
@@ -121,3 +198,51 @@ func analyze(db *sql.DB) {
 		log.Fatalf("error running analyze: %s", err)
 	}
 }
+
+type Column struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	NotNull    bool   `json:"not-null"`
+	PrimaryKey bool   `json:"primary-key"`
+}
+
+func readColumns(tableName string, db *sql.DB) []Column {
+	return readSchemaColumns(tableName, "main", db)
+}
+
+// readSchemaColumns returns the columns of the given table in the given schema (e.g. of an attached database)
+func readSchemaColumns(tableName string, schema string, db *sql.DB) []Column {
+	rows, err := db.Query("select name, type, \"notnull\", pk from pragma_table_info(?, ?)", tableName, schema)
+	if err != nil {
+		log.Fatalf("error reading columns of table '%s' of %s: %s", tableName, schema, err)
+	}
+	defer rows.Close()
+
+	var result []Column
+	for rows.Next() {
+		var column Column
+		var pk int
+		err = rows.Scan(&column.Name, &column.Type, &column.NotNull, &pk)
+		if err != nil {
+			log.Fatal(err)
+		}
+		column.PrimaryKey = pk > 0
+		result = append(result, column)
+	}
+	return result
+}
+
+// detectFidColumn returns the integer primary key column of the given table, which is an alias for the rowid
+func detectFidColumn(tableName string, db *sql.DB) string {
+	var pkColumns []Column
+	for _, column := range readColumns(tableName, db) {
+		if column.PrimaryKey {
+			pkColumns = append(pkColumns, column)
+		}
+	}
+	if len(pkColumns) != 1 || !strings.EqualFold(pkColumns[0].Type, "INTEGER") {
+		log.Printf("WARNING: no integer primary key found for table '%s', assuming 'fid'", tableName)
+		return "fid"
+	}
+	return pkColumns[0].Name
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+func TestDetectFidColumn(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE arcgis (OBJECTID INTEGER PRIMARY KEY, Shape BLOB)", db)
+	executeQuery("CREATE TABLE composite (a INTEGER, b INTEGER, PRIMARY KEY (a, b))", db)
+
+	if fid := detectFidColumn("arcgis", db); fid != "OBJECTID" {
+		log.Fatalf("expected fid column 'OBJECTID', got '%s'", fid)
+	}
+	if fid := detectFidColumn("composite", db); fid != "fid" {
+		log.Fatalf("expected fallback fid column 'fid', got '%s'", fid)
+	}
+}
+
+func TestReadTablesWithoutGeometryColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// gpkg_geometry_columns is optional in a GeoPackage with only attribute tables
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL)", db)
+	executeQuery("INSERT INTO gpkg_contents VALUES ('eigenaar', 'attributes')", db)
+
+	tables := readTables(db)
+	if len(tables) != 1 || tables[0].Name != "eigenaar" || tables[0].IsFeatures || len(tables[0].GeometryColumns) != 0 {
+		log.Fatalf("expected attribute table 'eigenaar' without geometry columns, got %v", tables)
+	}
+	for _, check := range checkConformance(db).Checks {
+		if check.Name == "required table gpkg_geometry_columns" {
+			log.Fatal("expected gpkg_geometry_columns not to be required without feature tables")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+const (
+	validationActionReport  = "report"
+	validationActionRepair  = "repair"
+	validationActionExclude = "exclude"
+)
+
+// validateGeometries reports NULL, empty and invalid geometries of every registered geometry column of the given table,
+// and optionally repairs (ST_MakeValid) the invalid geometries or excludes (deletes) the features with a NULL, empty or
+// invalid geometry. Should be performed before any bbox is computed.
+func validateGeometries(table Table, fidColumn string, validation Validation, report *Report, db *sql.DB) {
+	if !table.IsFeatures {
+		return
+	}
+	action := validation.Action
+	if action == "" {
+		action = validationActionReport
+	}
+	if action != validationActionReport && action != validationActionRepair && action != validationActionExclude {
+		log.Fatalf("invalid validation action '%s' for table '%s'", action, table.Name)
+	}
+
+	report.table(table.Name).InvalidGeometries = nil
+	for _, geomColumn := range table.GeometryColumns {
+		invalid := validateGeometryColumn(table, fidColumn, geomColumn, action, db)
+		report.table(table.Name).InvalidGeometries = append(report.table(table.Name).InvalidGeometries, invalid...)
+	}
+}
+
+// validateGeometryColumn validates a single geometry column of the given table, see validateGeometries
+func validateGeometryColumn(table Table, fidColumn string, geomColumn GeometryColumn, action string, db *sql.DB) []InvalidGeometryReport {
+	geometry := fmt.Sprintf("GeomFromGPB(\"%s\")", geomColumn.Name)
+	reason := fmt.Sprintf("case when \"%[1]s\" is null then 'null geometry' "+
+		"when %[2]s is null or ST_IsEmpty(%[2]s) = 1 then 'empty geometry' "+
+		"when ST_IsValid(%[2]s) = 0 then coalesce(ST_IsValidReason(%[2]s), 'invalid geometry') end", geomColumn.Name, geometry)
+	rows, err := db.Query(fmt.Sprintf("select fid, reason from (select \"%s\" as fid, %s as reason from \"%s\") where reason is not null order by fid",
+		fidColumn, reason, table.Name))
+	if err != nil {
+		log.Fatalf("error validating geometry column '%s' of table '%s': %s", geomColumn.Name, table.Name, err)
+	}
+	var invalid []InvalidGeometryReport
+	for rows.Next() {
+		geometryReport := InvalidGeometryReport{Column: geomColumn.Name, Action: action}
+		err = rows.Scan(&geometryReport.Fid, &geometryReport.Reason)
+		if err != nil {
+			log.Fatal(err)
+		}
+		invalid = append(invalid, geometryReport)
+	}
+	rows.Close()
+
+	log.Printf("Found %d NULL, empty or invalid geometries in geometry column '%s' of table '%s'", len(invalid), geomColumn.Name, table.Name)
+	for _, geometryReport := range invalid {
+		log.Printf("%s %s: %s", table.Name, geometryReport.Fid, geometryReport.Reason)
+	}
+	if len(invalid) == 0 {
+		return invalid
+	}
+
+	switch action {
+	case validationActionRepair:
+		repairGeometries(table, fidColumn, geomColumn, invalid, db)
+	case validationActionExclude:
+		executeQuery(fmt.Sprintf("DELETE FROM \"%s\" WHERE (%s) is not null", table.Name, reason), db)
+	}
+	return invalid
+}
+
+// repairGeometries repairs the given invalid geometries using ST_MakeValid. ST_MakeValid may change the geometry type
+// (e.g. a self-intersecting polygon may become a collection of a polygon and a line), so a repaired geometry that doesn't
+// match the registered geometry type isn't stored, the original geometry is only reported instead. NULL and empty
+// geometries can't be repaired, these are only reported as well.
+func repairGeometries(table Table, fidColumn string, geomColumn GeometryColumn, invalid []InvalidGeometryReport, db *sql.DB) {
+	for i, geometryReport := range invalid {
+		if geometryReport.Reason == "null geometry" || geometryReport.Reason == "empty geometry" {
+			invalid[i].Action = validationActionReport
+			continue
+		}
+		var repaired []byte
+		err := db.QueryRow(fmt.Sprintf("select AsGPB(ST_MakeValid(GeomFromGPB(\"%s\"))) from \"%s\" where \"%s\" = ?",
+			geomColumn.Name, table.Name, fidColumn), geometryReport.Fid).Scan(&repaired)
+		if err != nil {
+			log.Fatalf("error repairing geometry %s of table '%s': %s", geometryReport.Fid, table.Name, err)
+		}
+		header, err := parseGeometryHeader(repaired)
+		if err != nil || header.Empty || !geometryTypeMatches(geomColumn.GeometryType, header.GeometryType) {
+			repairedType := "no geometry"
+			if err == nil {
+				repairedType = geometryTypeNames[header.GeometryType]
+			}
+			log.Printf("WARNING: geometry %s of table '%s' is not repaired, since the repaired geometry (%s) doesn't match geometry type %s of column '%s'",
+				geometryReport.Fid, table.Name, repairedType, geomColumn.GeometryType, geomColumn.Name)
+			invalid[i].Action = validationActionReport
+			invalid[i].Reason = fmt.Sprintf("%s (repair results in %s instead of %s)", geometryReport.Reason, repairedType, geomColumn.GeometryType)
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("update \"%s\" set \"%s\" = ? where \"%s\" = ?", table.Name, geomColumn.Name, fidColumn),
+			repaired, geometryReport.Fid)
+		if err != nil {
+			log.Fatalf("error storing repaired geometry %s of table '%s': %s", geometryReport.Fid, table.Name, err)
+		}
+	}
+}
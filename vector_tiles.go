@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vector tiles extensions, see https://gitlab.com/imagemattersllc/ogc-vtp2/-/tree/master/extensions
+const (
+	vectorTilesExtension           = "im_vector_tiles"
+	vectorTilesExtensionDefinition = "https://gitlab.com/imagemattersllc/ogc-vtp2/-/blob/master/extensions/1-vte.adoc"
+	mapboxTilesExtension           = "im_vector_tiles_mapbox"
+	mapboxTilesExtensionDefinition = "https://gitlab.com/imagemattersllc/ogc-vtp2/-/blob/master/extensions/2-mvte.adoc"
+	vectorTilesDataType            = "vector-tiles"
+)
+
+// vectorTilesLayer is a prepared feature table that is encoded as layer in the vector tiles
+type vectorTilesLayer struct {
+	table      Table
+	fidColumn  string
+	source     GeometryColumn
+	attributes []string
+	minZoom    int
+	// companion table with simplified geometries per zoom level
+	zoomTables map[int]string
+}
+
+// zoomTable returns the companion table with the simplified geometries to use at the given zoom level: the table of the
+// zoom level itself, or else of the next finer zoom level. Returns false when the geometry itself is to be used.
+func (l vectorTilesLayer) zoomTable(zoom int) (string, bool) {
+	finest := -1
+	for z := range l.zoomTables {
+		if z >= zoom && (finest == -1 || z < finest) {
+			finest = z
+		}
+	}
+	if finest == -1 {
+		return "", false
+	}
+	return l.zoomTables[finest], true
+}
+
+// generateVectorTiles encodes the features of the given layers as Mapbox Vector Tiles, stored (gzip compressed) in a
+// GeoPackage tiles table with its tile matrix set. Features are selected per tile using the bbox columns.
+func generateVectorTiles(config VectorTiles, layers []vectorTilesLayer, report *Report, db *sql.DB) {
+	tileMatrixSet := findTileMatrixSet(config.TileMatrixSet)
+	if config.MinZoom < 0 || config.MaxZoom < config.MinZoom || config.MaxZoom > 24 {
+		log.Fatalf("invalid zoom levels %d-%d for vector tiles", config.MinZoom, config.MaxZoom)
+	}
+	if tableExists(config.TableName, db) {
+		log.Fatalf("table '%s' for vector tiles already exists", config.TableName)
+	}
+	log.Printf("Generating vector tiles in table '%s' for tile matrix set %s, zoom levels %d-%d",
+		config.TableName, tileMatrixSet.ID, config.MinZoom, config.MaxZoom)
+
+	registerCRS(tileMatrixSet.CRS, db)
+	executeQuery(fmt.Sprintf("CREATE TABLE \"%s\" (id INTEGER PRIMARY KEY AUTOINCREMENT, zoom_level INTEGER NOT NULL, "+
+		"tile_column INTEGER NOT NULL, tile_row INTEGER NOT NULL, tile_data BLOB NOT NULL, "+
+		"UNIQUE (zoom_level, tile_column, tile_row))", config.TableName), db)
+	recordArtifact(config.TableName, artifactTable, config.TableName, db)
+
+	extent, tileCount := writeVectorTiles(config, tileMatrixSet, layers, db)
+	if extent[0] > extent[2] || extent[1] > extent[3] {
+		log.Printf("WARNING: the layers of the vector tiles in table '%s' contain no features, its extent is the extent of tile matrix set %s",
+			config.TableName, tileMatrixSet.ID)
+		extent = tileMatrixSet.bounds()
+	}
+
+	registerTileMatrixSet(config.TableName, vectorTilesDataType, tileMatrixSet, config.MinZoom, config.MaxZoom, extent, db)
+	registerExtension(config.TableName, "", vectorTilesExtension, vectorTilesExtensionDefinition, "read-write", db)
+	registerExtension(config.TableName, "", mapboxTilesExtension, mapboxTilesExtensionDefinition, "read-write", db)
+
+	var layerNames []string
+	for _, layer := range layers {
+		layerNames = append(layerNames, layer.table.Name)
+	}
+	report.table(config.TableName).VectorTiles = &VectorTilesReport{
+		TileMatrixSet: tileMatrixSet.ID,
+		MinZoom:       config.MinZoom,
+		MaxZoom:       config.MaxZoom,
+		Layers:        layerNames,
+		TileCount:     tileCount,
+	}
+}
+
+// writeVectorTiles encodes and stores every tile containing features, returns the extent of the features (in the CRS
+// of the tile matrix set) and the number of tiles. All statements are executed on a single connection, within a
+// savepoint, so the tiles are written in a single transaction.
+func writeVectorTiles(config VectorTiles, tileMatrixSet TileMatrixSet, layers []vectorTilesLayer, db *sql.DB) ([4]float64, int64) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatalf("error opening connection: %s", err)
+	}
+	defer conn.Close()
+	execConn(ctx, conn, "SAVEPOINT pdok_vector_tiles")
+
+	extent, tileRanges := readTileRanges(ctx, conn, tileMatrixSet, layers, config.MaxZoom)
+	insert := fmt.Sprintf("insert into \"%s\" (zoom_level, tile_column, tile_row, tile_data) values (?, ?, ?, ?)", config.TableName)
+	var tileCount int64
+	for zoom := config.MinZoom; zoom <= config.MaxZoom; zoom++ {
+		tiles := candidateTiles(tileRanges, layers, config.MaxZoom-zoom, zoom)
+		log.Printf("Encoding %d vector tiles at zoom level %d", len(tiles), zoom)
+		for _, tile := range tiles {
+			var mvtLayers []*MVTLayer
+			for _, layer := range layers {
+				if zoom < layer.minZoom {
+					continue
+				}
+				mvtLayers = append(mvtLayers, encodeTileLayer(ctx, conn, config, tileMatrixSet, layer, zoom, tile))
+			}
+			data := encodeTile(mvtLayers)
+			if len(data) == 0 {
+				continue
+			}
+			_, err = conn.ExecContext(ctx, insert, zoom, tile[0], tile[1], gzipTile(data))
+			if err != nil {
+				log.Fatalf("error inserting vector tile %d/%d/%d: %s", zoom, tile[0], tile[1], err)
+			}
+			tileCount++
+		}
+	}
+
+	execConn(ctx, conn, "RELEASE pdok_vector_tiles")
+	return extent, tileCount
+}
+
+// readTileRanges returns the extent of all features (inverted when there are none) and, per layer, the range of tiles
+// (min x, max x, min y, max y) covered by the bbox of every feature at the given zoom level
+func readTileRanges(ctx context.Context, conn *sql.Conn, tileMatrixSet TileMatrixSet, layers []vectorTilesLayer, zoom int) ([4]float64, [][][4]int) {
+	extent := [4]float64{tileMatrixSet.OriginX + tileMatrixSet.Span, tileMatrixSet.OriginY, tileMatrixSet.OriginX, tileMatrixSet.OriginY - tileMatrixSet.Span}
+	span := tileMatrixSet.tileSpan(zoom)
+	maxTile := (1 << zoom) - 1
+	tile := func(value float64) int {
+		return max(0, min(maxTile, int(value/span)))
+	}
+
+	var result [][][4]int
+	for _, layer := range layers {
+		bbox := []string{"minx", "miny", "maxx", "maxy"}
+		if layer.source.SrsID != tileMatrixSet.CRS {
+			for i, component := range bbox {
+				bbox[i] = transformBBoxExpression(layer.source.Name, layer.source.SrsID, tileMatrixSet.CRS, component)
+			}
+		}
+		rows, err := conn.QueryContext(ctx, fmt.Sprintf("select %s from \"%s\" where minx is not null",
+			strings.Join(bbox, ", "), layer.table.Name))
+		if err != nil {
+			log.Fatalf("error reading bboxes of table '%s': %s", layer.table.Name, err)
+		}
+		var ranges [][4]int
+		for rows.Next() {
+			var bbox [4]float64
+			err = rows.Scan(&bbox[0], &bbox[1], &bbox[2], &bbox[3])
+			if err != nil {
+				log.Fatal(err)
+			}
+			extent = [4]float64{min(extent[0], bbox[0]), min(extent[1], bbox[1]), max(extent[2], bbox[2]), max(extent[3], bbox[3])}
+			ranges = append(ranges, [4]int{
+				tile(bbox[0] - tileMatrixSet.OriginX),
+				tile(bbox[2] - tileMatrixSet.OriginX),
+				tile(tileMatrixSet.OriginY - bbox[3]),
+				tile(tileMatrixSet.OriginY - bbox[1]),
+			})
+		}
+		rows.Close()
+		result = append(result, ranges)
+	}
+	return extent, result
+}
+
+// candidateTiles returns the tiles (x, y) at the given zoom level covered by the bbox of any feature, in order,
+// given the tile ranges at a zoom level that is shift levels deeper
+func candidateTiles(tileRanges [][][4]int, layers []vectorTilesLayer, shift int, zoom int) [][2]int {
+	set := make(map[[2]int]bool)
+	for i, ranges := range tileRanges {
+		if zoom < layers[i].minZoom {
+			continue
+		}
+		for _, r := range ranges {
+			for x := r[0] >> shift; x <= r[1]>>shift; x++ {
+				for y := r[2] >> shift; y <= r[3]>>shift; y++ {
+					set[[2]int{x, y}] = true
+				}
+			}
+		}
+	}
+	tiles := make([][2]int, 0, len(set))
+	for tile := range set {
+		tiles = append(tiles, tile)
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i][1] < tiles[j][1] || (tiles[i][1] == tiles[j][1] && tiles[i][0] < tiles[j][0])
+	})
+	return tiles
+}
+
+// encodeTileLayer encodes the features of the given layer intersecting the (buffered) tile
+func encodeTileLayer(ctx context.Context, conn *sql.Conn, config VectorTiles, tileMatrixSet TileMatrixSet, layer vectorTilesLayer,
+	zoom int, tile [2]int) *MVTLayer {
+	span := tileMatrixSet.tileSpan(zoom)
+	transform := tileTransform{
+		minX:   tileMatrixSet.OriginX + float64(tile[0])*span,
+		maxY:   tileMatrixSet.OriginY - float64(tile[1])*span,
+		span:   span,
+		extent: config.Extent,
+	}
+	buffer := float64(config.Buffer) / float64(config.Extent) * span
+	bbox := [4]float64{transform.minX - buffer, transform.maxY - span - buffer, transform.minX + span + buffer, transform.maxY + buffer}
+	if layer.source.SrsID != tileMatrixSet.CRS {
+		bbox = transformTileBBox(ctx, conn, bbox, tileMatrixSet.CRS, layer.source.SrsID)
+	}
+
+	var columns []string
+	for _, column := range append([]string{layer.fidColumn}, layer.attributes...) {
+		columns = append(columns, fmt.Sprintf("t.\"%s\"", column))
+	}
+	join := ""
+	geometryColumn := fmt.Sprintf("t.\"%s\"", layer.source.Name)
+	if zoomTable, ok := layer.zoomTable(zoom); ok {
+		geometryColumn = fmt.Sprintf("z.\"%s\"", layer.source.Name)
+		join = fmt.Sprintf(" left join \"%s\" z on z.\"%s\" = t.\"%[2]s\"", zoomTable, layer.fidColumn)
+	}
+	if layer.source.SrsID != tileMatrixSet.CRS {
+		geometryColumn = fmt.Sprintf("AsGPB(ST_Transform(GeomFromGPB(%s), %d))", geometryColumn, tileMatrixSet.CRS)
+	}
+	columns = append(columns, geometryColumn)
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("select %s from \"%s\" t%s where t.minx <= ? and t.maxx >= ? and t.miny <= ? and t.maxy >= ?",
+		strings.Join(columns, ", "), layer.table.Name, join), bbox[2], bbox[0], bbox[3], bbox[1])
+	if err != nil {
+		log.Fatalf("error selecting features of table '%s': %s", layer.table.Name, err)
+	}
+	defer rows.Close()
+
+	mvtLayer := newMVTLayer(layer.table.Name, config.Extent, config.Buffer)
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		blob, ok := values[len(values)-1].([]byte)
+		if !ok {
+			continue
+		}
+		geometry, err := decodeGeometry(blob)
+		if err != nil {
+			log.Printf("WARNING: skipping feature %v of table '%s' in vector tiles: %s", values[0], layer.table.Name, err)
+			continue
+		}
+		geometry = geometry.mapPoints(transform.apply)
+
+		var id *uint64
+		if fid, ok := values[0].(int64); ok && fid >= 0 {
+			id = new(uint64)
+			*id = uint64(fid)
+		}
+		attributes := make(map[string]any)
+		for i, attribute := range layer.attributes {
+			attributes[attribute] = values[i+1]
+		}
+		mvtLayer.addFeature(id, geometry, attributes)
+	}
+	return mvtLayer
+}
+
+// transformTileBBox transforms the bbox of a tile to the CRS of a layer, in Go between RD New and WGS84 within the
+// Netherlands and by SpatiaLite otherwise, see transformBBox
+func transformTileBBox(ctx context.Context, conn *sql.Conn, bbox [4]float64, from, to int64) [4]float64 {
+	transformed, err := transformBBox(bbox[0], bbox[1], bbox[2], bbox[3], from, to)
+	if err == nil {
+		return transformed
+	}
+	var points []string
+	for _, point := range bboxEdgePoints(bbox[0], bbox[1], bbox[2], bbox[3]) {
+		points = append(points, strconv.FormatFloat(point[0], 'f', -1, 64)+" "+strconv.FormatFloat(point[1], 'f', -1, 64))
+	}
+	err = conn.QueryRowContext(ctx, "select ST_MinX(g), ST_MinY(g), ST_MaxX(g), ST_MaxY(g) "+
+		"from (select ST_Transform(MPointFromText(?, ?), ?) as g)", "MULTIPOINT("+strings.Join(points, ", ")+")", from, to).
+		Scan(&transformed[0], &transformed[1], &transformed[2], &transformed[3])
+	if err != nil {
+		log.Fatalf("error transforming tile bbox from EPSG:%d to EPSG:%d: %s", from, to, err)
+	}
+	return transformed
+}
+
+// mapPoints returns a copy of the geometry with every point mapped by the given function
+func (g Geometry) mapPoints(f func(point [2]float64) [2]float64) Geometry {
+	mapPath := func(path [][2]float64) [][2]float64 {
+		result := make([][2]float64, len(path))
+		for i, point := range path {
+			result[i] = f(point)
+		}
+		return result
+	}
+	var result Geometry
+	result.Points = mapPath(g.Points)
+	for _, line := range g.Lines {
+		result.Lines = append(result.Lines, mapPath(line))
+	}
+	for _, polygon := range g.Polygons {
+		var rings [][][2]float64
+		for _, ring := range polygon {
+			rings = append(rings, mapPath(ring))
+		}
+		result.Polygons = append(result.Polygons, rings)
+	}
+	return result
+}
+
+func gzipTile(data []byte) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Fatalf("error compressing vector tile: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func execConn(ctx context.Context, conn *sql.Conn, query string) {
+	log.Printf("executing query: %s;\n", query)
+	_, err := conn.ExecContext(ctx, query)
+	if err != nil {
+		log.Fatalf("error executing query: '%s'", err)
+	}
+}
+
+// registerTileMatrixSet registers a tiles table in gpkg_contents, gpkg_tile_matrix_set and gpkg_tile_matrix (for the
+// given zoom levels), creating the tile matrix tables when missing
+func registerTileMatrixSet(tableName string, dataType string, tileMatrixSet TileMatrixSet, minZoom int, maxZoom int, extent [4]float64, db *sql.DB) {
+	if !tableExists("gpkg_tile_matrix_set", db) {
+		executeQuery("CREATE TABLE gpkg_tile_matrix_set (table_name TEXT NOT NULL PRIMARY KEY, srs_id INTEGER NOT NULL, "+
+			"min_x DOUBLE NOT NULL, min_y DOUBLE NOT NULL, max_x DOUBLE NOT NULL, max_y DOUBLE NOT NULL, "+
+			"CONSTRAINT fk_gtms_table_name FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name), "+
+			"CONSTRAINT fk_gtms_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))", db)
+		recordArtifact("", artifactTable, "gpkg_tile_matrix_set", db)
+	}
+	if !tableExists("gpkg_tile_matrix", db) {
+		executeQuery("CREATE TABLE gpkg_tile_matrix (table_name TEXT NOT NULL, zoom_level INTEGER NOT NULL, "+
+			"matrix_width INTEGER NOT NULL, matrix_height INTEGER NOT NULL, tile_width INTEGER NOT NULL, tile_height INTEGER NOT NULL, "+
+			"pixel_x_size DOUBLE NOT NULL, pixel_y_size DOUBLE NOT NULL, CONSTRAINT pk_ttm PRIMARY KEY (table_name, zoom_level), "+
+			"CONSTRAINT fk_tmm_table_name FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name))", db)
+		recordArtifact("", artifactTable, "gpkg_tile_matrix", db)
+	}
+
+	_, err := db.Exec("insert into gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id) "+
+		"values (?, ?, ?, ?, ?, ?, ?, ?)", tableName, dataType, tableName, extent[0], extent[1], extent[2], extent[3], tileMatrixSet.CRS)
+	if err != nil {
+		log.Fatalf("error registering '%s' in gpkg_contents: %s", tableName, err)
+	}
+	recordArtifact(tableName, artifactContents, tableName, db)
+
+	bounds := tileMatrixSet.bounds()
+	_, err = db.Exec("insert into gpkg_tile_matrix_set (table_name, srs_id, min_x, min_y, max_x, max_y) values (?, ?, ?, ?, ?, ?)",
+		tableName, tileMatrixSet.CRS, bounds[0], bounds[1], bounds[2], bounds[3])
+	if err != nil {
+		log.Fatalf("error registering '%s' in gpkg_tile_matrix_set: %s", tableName, err)
+	}
+	for zoom := minZoom; zoom <= maxZoom; zoom++ {
+		_, err = db.Exec("insert into gpkg_tile_matrix (table_name, zoom_level, matrix_width, matrix_height, tile_width, tile_height, "+
+			"pixel_x_size, pixel_y_size) values (?, ?, ?, ?, ?, ?, ?, ?)", tableName, zoom, 1<<zoom, 1<<zoom, tileSize, tileSize,
+			tileMatrixSet.resolution(zoom), tileMatrixSet.resolution(zoom))
+		if err != nil {
+			log.Fatalf("error registering zoom level %d of '%s' in gpkg_tile_matrix: %s", zoom, tableName, err)
+		}
+	}
+	recordArtifact(tableName, artifactTileMatrixSet, tableName, db)
+}
+
+// revertTileMatrixSet removes a tiles table added by the optimizer from gpkg_tile_matrix_set and gpkg_tile_matrix
+func revertTileMatrixSet(tableName string, db *sql.DB) {
+	for _, registry := range []string{"gpkg_tile_matrix_set", "gpkg_tile_matrix"} {
+		if !tableExists(registry, db) {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf("delete from %s where table_name = ?", registry), tableName)
+		if err != nil {
+			log.Fatalf("error removing '%s' from %s: %s", tableName, registry, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"testing"
+)
+
+// pointGeometry returns a GeoPackage binary point geometry
+func pointGeometry(x, y float64, srsID uint32) []byte {
+	blob := []byte{'G', 'P', 0, 0x01}
+	blob = binary.LittleEndian.AppendUint32(blob, srsID)
+	blob = append(blob, 1)
+	blob = binary.LittleEndian.AppendUint32(blob, 1)
+	blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(x))
+	return binary.LittleEndian.AppendUint64(blob, math.Float64bits(y))
+}
+
+func TestGenerateVectorTiles(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL, "+
+		"organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)", db)
+	executeQuery("INSERT INTO gpkg_spatial_ref_sys VALUES ('Amersfoort / RD New', 28992, 'EPSG', 28992, 'undefined', NULL)", db)
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT, "+
+		"description TEXT DEFAULT '', last_change DATETIME, min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER)", db)
+	executeQuery("CREATE TABLE buildings (fid INTEGER PRIMARY KEY, year INTEGER, geom POINT, minx numeric, maxx numeric, miny numeric, maxy numeric)", db)
+	// two buildings in Amersfoort, in the same tile at every zoom level up to 7
+	for fid, point := range map[int][2]float64{1: {156000, 463000}, 2: {160000, 463000}} {
+		_, err = db.Exec("insert into buildings values (?, 1920, ?, ?, ?, ?, ?)", fid, pointGeometry(point[0], point[1], 28992),
+			point[0], point[0], point[1], point[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	layers := []vectorTilesLayer{{
+		table:      Table{Name: "buildings", IsFeatures: true},
+		fidColumn:  "fid",
+		source:     GeometryColumn{Name: "geom", GeometryType: "POINT", SrsID: epsgRDNew},
+		attributes: []string{"year"},
+	}}
+	config := VectorTiles{TableName: "vector_tiles", TileMatrixSet: "NetherlandsRDNewQuad", MaxZoom: 8, Extent: 4096, Buffer: 64}
+	report := newReport("buildings.gpkg", "tiles")
+	generateVectorTiles(config, layers, report, db)
+
+	// zoom 8 tiles are 3.4 km wide, so there is a single tile per zoom level, except for zoom 8
+	if report.Tables["vector_tiles"].VectorTiles.TileCount != 10 {
+		log.Fatalf("expected 10 tiles, got %+v", report.Tables["vector_tiles"].VectorTiles)
+	}
+	var tileData []byte
+	err = db.QueryRow("select tile_data from vector_tiles where zoom_level = 0 and tile_column = 0 and tile_row = 0").Scan(&tileData)
+	if err != nil {
+		log.Fatalf("expected tile 0/0/0: %s", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(tileData))
+	if err != nil {
+		log.Fatalf("expected gzip compressed tile: %s", err)
+	}
+	tile, _ := io.ReadAll(reader)
+	if !bytes.Contains(tile, []byte("buildings")) || !bytes.Contains(tile, []byte("year")) {
+		log.Fatalf("expected layer buildings with attribute year, got %v", tile)
+	}
+
+	var zoomLevels int
+	err = db.QueryRow("select count(*) from gpkg_tile_matrix where table_name = 'vector_tiles'").Scan(&zoomLevels)
+	if err != nil || zoomLevels != 9 {
+		log.Fatalf("expected 9 zoom levels in gpkg_tile_matrix, got %d (%v)", zoomLevels, err)
+	}
+	if extensions := queryStrings("select extension_name from gpkg_extensions where table_name = 'vector_tiles'", db); len(extensions) != 2 {
+		log.Fatalf("expected vector tiles extensions, got %v", extensions)
+	}
+
+	revertArtifacts(db)
+	if tableExists("vector_tiles", db) || len(queryStrings("select table_name from gpkg_contents", db)) != 0 {
+		log.Fatal("expected vector tiles to be removed")
+	}
+}
+
+func TestVectorTilesLayerZoomTable(t *testing.T) {
+	layer := vectorTilesLayer{zoomTables: map[int]string{2: "buildings_geom_z2", 5: "buildings_geom_z5"}}
+	for zoom, expected := range map[int]string{0: "buildings_geom_z2", 2: "buildings_geom_z2", 3: "buildings_geom_z5", 5: "buildings_geom_z5", 6: ""} {
+		zoomTable, ok := layer.zoomTable(zoom)
+		if zoomTable != expected || ok != (expected != "") {
+			log.Fatalf("expected zoom table '%s' at zoom level %d, got '%s'", expected, zoom, zoomTable)
+		}
+	}
+}
+
+func TestGenerateVectorTilesEmptyLayer(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executeQuery("CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL, "+
+		"organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)", db)
+	executeQuery("INSERT INTO gpkg_spatial_ref_sys VALUES ('Amersfoort / RD New', 28992, 'EPSG', 28992, 'undefined', NULL)", db)
+	executeQuery("CREATE TABLE gpkg_contents (table_name TEXT PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT, "+
+		"description TEXT DEFAULT '', last_change DATETIME, min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER)", db)
+	executeQuery("CREATE TABLE buildings (fid INTEGER PRIMARY KEY, geom POINT, minx numeric, maxx numeric, miny numeric, maxy numeric)", db)
+
+	layers := []vectorTilesLayer{{
+		table:     Table{Name: "buildings", IsFeatures: true},
+		fidColumn: "fid",
+		source:    GeometryColumn{Name: "geom", GeometryType: "POINT", SrsID: epsgRDNew},
+	}}
+	config := VectorTiles{TableName: "vector_tiles", TileMatrixSet: "NetherlandsRDNewQuad", MaxZoom: 4, Extent: 4096, Buffer: 64}
+	report := newReport("buildings.gpkg", "tiles")
+	generateVectorTiles(config, layers, report, db)
+
+	if report.Tables["vector_tiles"].VectorTiles.TileCount != 0 {
+		log.Fatalf("expected no tiles, got %+v", report.Tables["vector_tiles"].VectorTiles)
+	}
+	// without features the extent in gpkg_contents is the extent of the tile matrix set, instead of an inverted extent
+	var extent [4]float64
+	err = db.QueryRow("select min_x, min_y, max_x, max_y from gpkg_contents where table_name = 'vector_tiles'").
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		log.Fatalf("expected vector tiles in gpkg_contents: %s", err)
+	}
+	if extent != findTileMatrixSet("NetherlandsRDNewQuad").bounds() {
+		log.Fatalf("expected the extent of the tile matrix set, got %v", extent)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Geometry is a decoded geometry, flattened into its points, lines and polygons (a polygon is a list of rings,
+// the first being the exterior ring). Only x and y are kept.
+type Geometry struct {
+	Points   [][2]float64
+	Lines    [][][2]float64
+	Polygons [][][][2]float64
+}
+
+// decodeGeometry decodes a GeoPackage binary geometry, curved geometries are not supported
+func decodeGeometry(blob []byte) (Geometry, error) {
+	var geometry Geometry
+	header, err := parseGeometryHeader(blob)
+	if err != nil {
+		return geometry, err
+	}
+	if header.Empty {
+		return geometry, nil
+	}
+	if header.Extended {
+		return geometry, errors.New("extended GeoPackage binary geometries are not supported")
+	}
+	reader := &wkbReader{data: blob[header.WKBOffset:]}
+	err = reader.readGeometry(&geometry)
+	return geometry, err
+}
+
+type wkbReader struct {
+	data      []byte
+	offset    int
+	byteOrder binary.ByteOrder
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	if r.offset+4 > len(r.data) {
+		return 0, errors.New("WKB geometry is truncated")
+	}
+	value := r.byteOrder.Uint32(r.data[r.offset:])
+	r.offset += 4
+	return value, nil
+}
+
+// readPoints reads count points of the given number of dimensions, keeping x and y
+func (r *wkbReader) readPoints(count uint32, dimensions int) ([][2]float64, error) {
+	if r.offset+int(count)*dimensions*8 > len(r.data) {
+		return nil, errors.New("WKB geometry is truncated")
+	}
+	points := make([][2]float64, 0, count)
+	for i := uint32(0); i < count; i++ {
+		x := math.Float64frombits(r.byteOrder.Uint64(r.data[r.offset:]))
+		y := math.Float64frombits(r.byteOrder.Uint64(r.data[r.offset+8:]))
+		r.offset += dimensions * 8
+		points = append(points, [2]float64{x, y})
+	}
+	return points, nil
+}
+
+func (r *wkbReader) readRings(dimensions int) ([][][2]float64, error) {
+	count, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	var rings [][][2]float64
+	for i := uint32(0); i < count; i++ {
+		pointCount, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		ring, err := r.readPoints(pointCount, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+func (r *wkbReader) readGeometry(geometry *Geometry) error {
+	if r.offset >= len(r.data) {
+		return errors.New("WKB geometry is truncated")
+	}
+	r.byteOrder = binary.BigEndian
+	if r.data[r.offset] == 1 {
+		r.byteOrder = binary.LittleEndian
+	}
+	r.offset++
+	wkbType, err := r.readUint32()
+	if err != nil {
+		return err
+	}
+
+	// ISO WKB (e.g. 1001 for Point Z) and extended WKB (flags in the high bits) dimensions
+	dimensions := 2
+	switch wkbType / 1000 % 1000 {
+	case 1, 2:
+		dimensions = 3
+	case 3:
+		dimensions = 4
+	}
+	if wkbType&0x80000000 != 0 {
+		dimensions++
+	}
+	if wkbType&0x40000000 != 0 {
+		dimensions++
+	}
+	geometryType := (wkbType & 0x0FFFFFFF) % 1000
+
+	switch geometryType {
+	case 1:
+		points, err := r.readPoints(1, dimensions)
+		if err != nil {
+			return err
+		}
+		// empty points are encoded with NaN coordinates
+		if !math.IsNaN(points[0][0]) && !math.IsNaN(points[0][1]) {
+			geometry.Points = append(geometry.Points, points[0])
+		}
+	case 2:
+		count, err := r.readUint32()
+		if err != nil {
+			return err
+		}
+		line, err := r.readPoints(count, dimensions)
+		if err != nil {
+			return err
+		}
+		geometry.Lines = append(geometry.Lines, line)
+	case 3:
+		rings, err := r.readRings(dimensions)
+		if err != nil {
+			return err
+		}
+		if len(rings) > 0 {
+			geometry.Polygons = append(geometry.Polygons, rings)
+		}
+	case 4, 5, 6, 7:
+		count, err := r.readUint32()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < count; i++ {
+			// every part has its own byte order and type
+			err = r.readGeometry(geometry)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported WKB geometry type %d", wkbType)
+	}
+	return nil
+}